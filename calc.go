@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultCalcPrecision is how many decimals calculator results keep
+// unless configured; trailing zeros are dropped.
+const defaultCalcPrecision = 10
+
+// calcWindow is how many bytes of typing the built-in =expr= trigger can
+// span.
+const calcWindow = 128
+
+// calcFuncs are the functions calculator expressions may call.
+var calcFuncs = map[string]func(args []float64) (float64, error){
+	"sqrt":  calcFunc1(math.Sqrt),
+	"abs":   calcFunc1(math.Abs),
+	"round": calcFunc1(math.Round),
+	"floor": calcFunc1(math.Floor),
+	"ceil":  calcFunc1(math.Ceil),
+	"exp":   calcFunc1(math.Exp),
+	"ln":    calcFunc1(math.Log),
+	"log":   calcFunc1(math.Log10),
+	"sin":   calcFunc1(math.Sin),
+	"cos":   calcFunc1(math.Cos),
+	"tan":   calcFunc1(math.Tan),
+	"pow": func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("pow takes 2 arguments")
+		}
+		return math.Pow(args[0], args[1]), nil
+	},
+	"min": calcFuncN(math.Min),
+	"max": calcFuncN(math.Max),
+}
+
+// calcConsts are the named constants calculator expressions may use.
+var calcConsts = map[string]float64{"pi": math.Pi, "e": math.E}
+
+// calcFunc1 adapts a one-argument math function.
+func calcFunc1(f func(float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("takes 1 argument, got %d", len(args))
+		}
+		return f(args[0]), nil
+	}
+}
+
+// calcFuncN folds a two-argument math function over one or more arguments.
+func calcFuncN(f func(a, b float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("needs at least 1 argument")
+		}
+		v := args[0]
+		for _, a := range args[1:] {
+			v = f(v, a)
+		}
+		return v, nil
+	}
+}
+
+// evalCalc evaluates an arithmetic expression: + - * / ^ (power),
+// parentheses, percentages, the functions in calcFuncs and the constants
+// pi and e. "50%" is 0.5, but "200 + 10%" adds 10% of 200, as on a
+// pocket calculator.
+func evalCalc(expr string) (float64, error) {
+	p := &calcParser{s: expr}
+	v, _, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return 0, fmt.Errorf("unexpected %q at %d", p.s[p.pos:], p.pos)
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("result is not a number")
+	}
+	return v, nil
+}
+
+// formatCalc formats a result with precision decimals, dropping trailing
+// zeros.
+func formatCalc(v float64, precision int) string {
+	s := strconv.FormatFloat(v, 'f', precision, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+// calcResult is the capture the calculator match's replacement reads its
+// result from.
+const calcResult = "result"
+
+// maxCalcPrecision is the most decimals a float64 result can meaningfully
+// show.
+const maxCalcPrecision = 15
+
+// validateCalcVar checks a calc variable's params at load time. An
+// expression without {{refs}} is evaluated once to catch mistakes.
+func validateCalcVar(p VarParams) error {
+	if p.Expr == "" {
+		return fmt.Errorf("missing expr")
+	}
+	if p.Precision != nil && (*p.Precision < 0 || *p.Precision > maxCalcPrecision) {
+		return fmt.Errorf("precision must be between 0 and %d, got %d", maxCalcPrecision, *p.Precision)
+	}
+	if len(templateRefs(p.Expr)) == 0 {
+		if _, err := evalCalc(p.Expr); err != nil {
+			return fmt.Errorf("expr %q: %w", p.Expr, err)
+		}
+	}
+	return nil
+}
+
+// resolveCalc evaluates a calc variable's expression after expanding its
+// {{refs}}.
+func resolveCalc(name string, p VarParams, vars map[string]string, precision int) string {
+	if p.Precision != nil {
+		precision = *p.Precision
+	}
+	v, err := evalCalc(expandRefs(p.Expr, vars))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: calc var %q: %v\n", name, err)
+		return ""
+	}
+	return formatCalc(v, precision)
+}
+
+// calcParser is a recursive-descent parser that evaluates as it goes.
+// Each level returns whether its value was written as a percentage.
+type calcParser struct {
+	s   string
+	pos int
+}
+
+func (p *calcParser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end.
+func (p *calcParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+// sum parses terms joined by + and -.
+func (p *calcParser) sum() (float64, bool, error) {
+	v, pct, err := p.product()
+	if err != nil {
+		return 0, false, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return v, pct, nil
+		}
+		p.pos++
+		rhs, rhsPct, err := p.product()
+		if err != nil {
+			return 0, false, err
+		}
+		if rhsPct {
+			rhs *= v // a ± b% is relative to a
+		}
+		if op == '+' {
+			v += rhs
+		} else {
+			v -= rhs
+		}
+		pct = false
+	}
+}
+
+// product parses factors joined by *, × and /, ÷.
+func (p *calcParser) product() (float64, bool, error) {
+	v, pct, err := p.unary()
+	if err != nil {
+		return 0, false, err
+	}
+	for {
+		var op byte
+		switch {
+		case p.peek() == '*' || p.peek() == '/':
+			op = p.s[p.pos]
+			p.pos++
+		case strings.HasPrefix(p.s[p.pos:], "×"):
+			op = '*'
+			p.pos += len("×")
+		case strings.HasPrefix(p.s[p.pos:], "÷"):
+			op = '/'
+			p.pos += len("÷")
+		default:
+			return v, pct, nil
+		}
+		rhs, _, err := p.unary()
+		if err != nil {
+			return 0, false, err
+		}
+		if op == '*' {
+			v *= rhs
+		} else {
+			if rhs == 0 {
+				return 0, false, fmt.Errorf("division by zero")
+			}
+			v /= rhs
+		}
+		pct = false
+	}
+}
+
+// unary parses a signed power.
+func (p *calcParser) unary() (float64, bool, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		v, pct, err := p.unary()
+		return -v, pct, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.power()
+}
+
+// power parses a ^ b, right-associative.
+func (p *calcParser) power() (float64, bool, error) {
+	v, pct, err := p.postfix()
+	if err != nil {
+		return 0, false, err
+	}
+	if p.peek() != '^' {
+		return v, pct, nil
+	}
+	p.pos++
+	exp, _, err := p.unary()
+	if err != nil {
+		return 0, false, err
+	}
+	return math.Pow(v, exp), false, nil
+}
+
+// postfix parses a primary followed by an optional %.
+func (p *calcParser) postfix() (float64, bool, error) {
+	v, err := p.primary()
+	if err != nil {
+		return 0, false, err
+	}
+	if p.peek() == '%' {
+		p.pos++
+		return v / 100, true, nil
+	}
+	return v, false, nil
+}
+
+// primary parses a number, a parenthesized expression, a constant or a
+// function call.
+func (p *calcParser) primary() (float64, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		v, _, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return v, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.s) && (p.s[p.pos] >= '0' && p.s[p.pos] <= '9' || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", p.s[start:p.pos])
+		}
+		return v, nil
+	case isCalcLetter(c):
+		start := p.pos
+		for p.pos < len(p.s) && (isCalcLetter(p.s[p.pos]) || p.s[p.pos] >= '0' && p.s[p.pos] <= '9') {
+			p.pos++
+		}
+		name := strings.ToLower(p.s[start:p.pos])
+		if v, ok := calcConsts[name]; ok {
+			return v, nil
+		}
+		f, ok := calcFuncs[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown name %q", name)
+		}
+		if p.peek() != '(' {
+			return 0, fmt.Errorf("%s: missing (", name)
+		}
+		p.pos++
+		var args []float64
+		for p.peek() != ')' {
+			if len(args) > 0 {
+				if p.peek() != ',' {
+					return 0, fmt.Errorf("%s: missing , or )", name)
+				}
+				p.pos++
+			}
+			v, _, err := p.sum()
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, v)
+		}
+		p.pos++
+		v, err := f(args)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", name, err)
+		}
+		return v, nil
+	case c == 0:
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+	return 0, fmt.Errorf("unexpected %q", p.s[p.pos:])
+}
+
+// isCalcLetter reports whether c can start a function or constant name.
+func isCalcLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalCalc(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    string
+		wantErr string
+	}{
+		{"(12*7.5)+3", "93", ""},
+		{"2^10", "1024", ""},
+		{"2^3^2", "512", ""},
+		{"-2^2", "-4", ""},
+		{"sqrt(2)", "1.4142135624", ""},
+		{"80+15%", "92", ""},
+		{"80-25%", "60", ""},
+		{"50%", "0.5", ""},
+		{"6×7÷2", "21", ""},
+		{"max(1, 4, 2) + min(3, 1)", "5", ""},
+		{"round(pi*100)/100", "3.14", ""},
+		{"1/0", "", "division by zero"},
+		{"12*7,5", "", "unexpected"},
+		{"nope(1)", "", "unknown"},
+		{"(1+2", "", ""},
+	}
+	for _, tt := range tests {
+		v, err := evalCalc(tt.expr)
+		switch {
+		case tt.want == "" && tt.wantErr == "":
+			if err == nil {
+				t.Errorf("evalCalc(%q) = %v, want an error", tt.expr, v)
+			}
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("evalCalc(%q) error = %v, want %q", tt.expr, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("evalCalc(%q): %v", tt.expr, err)
+		default:
+			if got := formatCalc(v, defaultCalcPrecision); got != tt.want {
+				t.Errorf("evalCalc(%q) = %s, want %s", tt.expr, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCalculatorOptIn(t *testing.T) {
+	cfg := loadTestConfig(t, nil)
+	if cfg.calcMatch != nil {
+		t.Error("calculator enabled without calculator: true")
+	}
+}
+
+func TestCalculatorTrigger(t *testing.T) {
+	for _, mode := range []string{TriggerModeSpace, TriggerModeImmediate} {
+		cfg := loadTestConfig(t, map[string]string{
+			"config.yml": "calculator: true\ncalculator_precision: 2\ntrigger_mode: " + mode + "\n",
+		})
+		e := NewExpander(cfg, nil)
+		other := TriggerModeImmediate
+		if mode == TriggerModeImmediate {
+			other = TriggerModeSpace
+		}
+
+		c, ok := e.find("total =10/3=", mode, false)
+		if !ok {
+			t.Fatalf("%s: no calculator match", mode)
+		}
+		if c.typed != "=10/3=" || e.resolveReplacement(c) != "3.33" {
+			t.Errorf("%s: typed %q → %q, want =10/3= → 3.33", mode, c.typed, e.resolveReplacement(c))
+		}
+		if _, ok := e.find("total =10/3=", other, false); ok {
+			t.Errorf("%s: calculator fired in %s mode", mode, other)
+		}
+		for _, buf := range []string{"x=10/3=", "=abc=", "=1+=", "10/3="} {
+			if c, ok := e.find(buf, mode, false); ok {
+				t.Errorf("%s: find(%q) = %q, want no match", mode, buf, c.typed)
+			}
+		}
+
+		e.suppressed = cfg.calcMatch
+		if _, ok := e.find("=1+1=", mode, false); ok {
+			t.Errorf("%s: suppressed calculator fired", mode)
+		}
+	}
+}
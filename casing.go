@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Uppercase styles for propagate_case matches typed in all caps.
+const (
+	UppercaseStyleUppercase       = "uppercase"
+	UppercaseStyleCapitalize      = "capitalize"
+	UppercaseStyleCapitalizeWords = "capitalize_words"
+)
+
+// validUppercaseStyle reports whether style is a known uppercase style or
+// empty (uppercase).
+func validUppercaseStyle(style string) bool {
+	switch style {
+	case "", UppercaseStyleUppercase, UppercaseStyleCapitalize, UppercaseStyleCapitalizeWords:
+		return true
+	}
+	return false
+}
+
+// typedCase is the letter case a trigger was typed in.
+type typedCase int
+
+const (
+	caseLower typedCase = iota
+	caseCapitalized
+	caseUpper
+)
+
+// detectCase classifies the letters of a typed trigger: all uppercase (with
+// at least two letters), first letter uppercase, or anything else.
+// Non-letters such as a leading ' are ignored.
+func detectCase(typed string) typedCase {
+	letters, upper := 0, 0
+	firstUpper := false
+	for _, r := range typed {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if unicode.IsUpper(r) {
+			if letters == 0 {
+				firstUpper = true
+			}
+			upper++
+		}
+		letters++
+	}
+	switch {
+	case letters > 1 && upper == letters:
+		return caseUpper
+	case firstUpper:
+		return caseCapitalized
+	}
+	return caseLower
+}
+
+// propagateCase applies the case the trigger was typed in to the
+// replacement. A capitalized trigger capitalizes the first word (each word
+// with capitalize_words); an all-caps trigger applies style.
+func propagateCase(replacement, typed, style string) string {
+	switch detectCase(typed) {
+	case caseCapitalized:
+		if style == UppercaseStyleCapitalizeWords {
+			return capitalizeWords(replacement)
+		}
+		return capitalize(replacement)
+	case caseUpper:
+		switch style {
+		case UppercaseStyleCapitalize:
+			return capitalize(replacement)
+		case UppercaseStyleCapitalizeWords:
+			return capitalizeWords(replacement)
+		}
+		return strings.ToUpper(replacement)
+	}
+	return replacement
+}
+
+// capitalize uppercases the first letter of s.
+func capitalize(s string) string {
+	for i, r := range s {
+		if unicode.IsLetter(r) {
+			return s[:i] + string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+		}
+	}
+	return s
+}
+
+// capitalizeWords uppercases the first letter of every whitespace-separated
+// word in s.
+func capitalizeWords(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	atWordStart := true
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			atWordStart = true
+		case atWordStart && unicode.IsLetter(r):
+			r = unicode.ToUpper(r)
+			atWordStart = false
+		default:
+			atWordStart = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestDetectCase(t *testing.T) {
+	tests := []struct {
+		typed string
+		want  typedCase
+	}{
+		{"brb", caseLower},
+		{"Brb", caseCapitalized},
+		{"BRB", caseUpper},
+		{"bRB", caseLower},
+		{"BrB", caseCapitalized},
+		{"'date", caseLower},
+		{"'Date", caseCapitalized},
+		{"'DATE", caseUpper},
+		{":Ok", caseCapitalized},
+		{"A", caseCapitalized}, // one letter is never all caps
+		{"'A1", caseCapitalized},
+		{"ÉTÉ", caseUpper},
+		{"123", caseLower},
+	}
+	for _, tt := range tests {
+		if got := detectCase(tt.typed); got != tt.want {
+			t.Errorf("detectCase(%q) = %v, want %v", tt.typed, got, tt.want)
+		}
+	}
+}
+
+func TestPropagateCase(t *testing.T) {
+	const replacement = "be right back, ça va"
+	tests := []struct {
+		typed, style, want string
+	}{
+		{"brb", "", "be right back, ça va"},
+		{"Brb", "", "Be right back, ça va"},
+		{"Brb", UppercaseStyleCapitalize, "Be right back, ça va"},
+		{"Brb", UppercaseStyleCapitalizeWords, "Be Right Back, Ça Va"},
+		{"BRB", "", "BE RIGHT BACK, ÇA VA"},
+		{"BRB", UppercaseStyleUppercase, "BE RIGHT BACK, ÇA VA"},
+		{"BRB", UppercaseStyleCapitalize, "Be right back, ça va"},
+		{"BRB", UppercaseStyleCapitalizeWords, "Be Right Back, Ça Va"},
+		{"'Brb", "", "Be right back, ça va"},
+		{"'BRB", "", "BE RIGHT BACK, ÇA VA"},
+	}
+	for _, tt := range tests {
+		if got := propagateCase(replacement, tt.typed, tt.style); got != tt.want {
+			t.Errorf("propagateCase(%q, %q) = %q, want %q", tt.typed, tt.style, got, tt.want)
+		}
+	}
+
+	// The first letter is capitalized even after leading punctuation.
+	if got, want := propagateCase("(see below)", "Sb", ""), "(See below)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPropagateCaseExpansion(t *testing.T) {
+	ty := newTypist(t, map[string]string{"match/m.yml": `matches:
+  - trigger: "'date"
+    replace: "today is monday"
+    propagate_case: true
+    uppercase_style: capitalize_words
+`})
+	ty.typeText("'date 'Date 'DATE 'dAte ")
+	if got, want := ty.kb.String(), "today is monday Today Is Monday Today Is Monday today is monday |"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
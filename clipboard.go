@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// defaultClipboardTimeout bounds a wl-paste call. wl-paste can hang when
+// the clipboard owner does not answer.
+const defaultClipboardTimeout = time.Second
+
+// wlPaste returns the clipboard contents, or the primary selection, via
+// wl-paste without the trailing newline it adds.
+func wlPaste(primary bool, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := []string{"-n"}
+	if primary {
+		args = append(args, "--primary")
+	}
+	cmd := exec.CommandContext(ctx, "wl-paste", args...)
+	cmd.WaitDelay = 100 * time.Millisecond
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", errClipboardTimeout
+	}
+	return string(out), err
+}
+
+// errClipboardTimeout is returned by wlPaste when wl-paste hangs.
+var errClipboardTimeout = errors.New("wl-paste timed out")
+
+// validateClipboardVar checks a clipboard or selection variable's params
+// at load time.
+func validateClipboardVar(p VarParams) error {
+	_, err := paramTimeout(p, defaultClipboardTimeout)
+	return err
+}
+
+// resolveClipboard returns the clipboard (or with primary, the selected
+// text) for a clipboard/selection variable. It is empty when nothing is
+// copied or wl-paste fails.
+func resolveClipboard(name string, p VarParams, primary bool) string {
+	timeout, err := paramTimeout(p, defaultClipboardTimeout)
+	if err != nil {
+		timeout = defaultClipboardTimeout
+	}
+	text, err := wlPaste(primary, timeout)
+	if errors.Is(err, errClipboardTimeout) {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: clipboard var %q: %v after %v\n", name, err, timeout)
+		return ""
+	}
+	if err != nil {
+		// wl-paste also fails when nothing is copied.
+		dbg("clipboard var %q: %v", name, err)
+		return ""
+	}
+	return text
+}
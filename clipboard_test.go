@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeWlPaste puts a wl-paste running script first on PATH.
+func fakeWlPaste(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wl-paste"), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestResolveClipboard(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		params  VarParams
+		primary bool
+		want    string
+	}{
+		{"clipboard", `printf 'copied %s' "$*"`, VarParams{}, false, "copied -n"},
+		{"selection", `printf 'copied %s' "$*"`, VarParams{}, true, "copied -n --primary"},
+		{"nothing copied", "echo 'Nothing is copied' >&2; exit 1", VarParams{}, false, ""},
+		{"hangs", "sleep 10", VarParams{Timeout: "200ms"}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeWlPaste(t, tt.script)
+			start := time.Now()
+			if got := resolveClipboard("clip", tt.params, tt.primary); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("took %v, want the timeout to stop it", elapsed)
+			}
+		})
+	}
+}
+
+func TestWlPasteTimeout(t *testing.T) {
+	fakeWlPaste(t, "sleep 10")
+	if _, err := wlPaste(false, 200*time.Millisecond); !errors.Is(err, errClipboardTimeout) {
+		t.Errorf("got error %v, want %v", err, errClipboardTimeout)
+	}
+}
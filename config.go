@@ -1,24 +1,91 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
-	"sort"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/holoplot/go-evdev"
+	"golang.org/x/text/unicode/norm"
 	"gopkg.in/yaml.v3"
 )
 
 // AppConfig holds global application settings from config.yml.
 type AppConfig struct {
-	ConfigVersion int    `yaml:"config_version"`
-	TriggerMode   string `yaml:"trigger_mode"`
+	ConfigVersion  int      `yaml:"config_version"`
+	TriggerMode    string   `yaml:"trigger_mode"`
+	KeyboardLayout string   `yaml:"keyboard_layout"`
+	WordSeparators []string `yaml:"word_separators"`
+	RegexWindow    int      `yaml:"regex_window"`
+	TriggerKeys    []string `yaml:"trigger_keys"`
+	UndoBackspace  bool     `yaml:"undo_backspace"`
+	UndoSuppress   bool     `yaml:"undo_suppress"`
+	Locale         string   `yaml:"locale"`
+
+	Calculator          bool `yaml:"calculator"`
+	CalculatorPrecision int  `yaml:"calculator_precision"`
+
+	Plugins []PluginDef `yaml:"plugins"`
+}
+
+// defaultRegexWindow is how many recently typed characters regex triggers
+// can look back over.
+const defaultRegexWindow = 50
+
+// defaultWordSeparators are the characters that delimit words for the
+// word, left_word and right_word match options (espanso's defaults).
+var defaultWordSeparators = []string{
+	" ", "\t", "\n", ",", ".", "?", "!", ";", ":", "(", ")", "[", "]", "{", "}",
+}
+
+// punctuationTriggerKeys are the characters the "punctuation" trigger_keys
+// entry stands for.
+var punctuationTriggerKeys = []rune{'.', ',', ';', ':', '!', '?'}
+
+// parseTriggerKeys turns trigger_keys entries into the characters and the
+// non-character keys (tab, enter) that fire "space" mode matches. Besides
+// the named entries any single character is accepted.
+func parseTriggerKeys(keys []string) (map[rune]bool, map[evdev.EvCode]string, error) {
+	chars := make(map[rune]bool)
+	codes := make(map[evdev.EvCode]string)
+	for _, k := range keys {
+		switch k {
+		case "space":
+			chars[' '] = true
+		case "tab", "\t":
+			codes[evdev.KEY_TAB] = "\t"
+		case "enter", "\n":
+			codes[evdev.KEY_ENTER] = "\n"
+			codes[evdev.KEY_KPENTER] = "\n"
+		case "punctuation":
+			for _, r := range punctuationTriggerKeys {
+				chars[r] = true
+			}
+		default:
+			if utf8.RuneCountInString(k) != 1 {
+				return nil, nil, fmt.Errorf("invalid trigger_keys entry %q", k)
+			}
+			r, _ := utf8.DecodeRuneInString(k)
+			chars[r] = true
+		}
+	}
+	return chars, codes, nil
 }
 
 // ConfigFile represents a single YAML config file (espanso-compatible).
+// TriggerMode, if set, is the default for every match in the file.
 type ConfigFile struct {
-	GlobalVars []VarDef   `yaml:"global_vars"`
-	Matches    []MatchDef `yaml:"matches"`
+	TriggerMode string     `yaml:"trigger_mode"`
+	GlobalVars  []VarDef   `yaml:"global_vars"`
+	Matches     []MatchDef `yaml:"matches"`
 }
 
 // VarDef defines a variable (e.g. a date variable).
@@ -28,38 +95,229 @@ type VarDef struct {
 	Params VarParams `yaml:"params"`
 }
 
-// VarParams holds parameters for a variable definition.
+// VarParams holds parameters for a variable definition. Each variable
+// type reads its own subset.
 type VarParams struct {
-	Format string `yaml:"format"`
-	Offset int    `yaml:"offset"`
+	// date (format also applies to counter)
+	Format       string       `yaml:"format"`
+	Offset       dateOffset   `yaml:"offset"`
+	Weekday      string       `yaml:"weekday"`
+	Snap         string       `yaml:"snap"`
+	SkipWeekends bool         `yaml:"skip_weekends"`
+	Locale       string       `yaml:"locale"`
+	Timezone     dateTimezone `yaml:"timezone"`
+
+	// shell (timeout also applies to clipboard and selection)
+	Cmd     string            `yaml:"cmd"`
+	Shell   string            `yaml:"shell"`
+	Timeout string            `yaml:"timeout"`
+	Trim    *bool             `yaml:"trim"`
+	Env     map[string]string `yaml:"env"`
+
+	// random
+	Choices []string `yaml:"choices"`
+	Choice  string   `yaml:"choice"`
+
+	// match
+	Trigger string `yaml:"trigger"`
+
+	// counter
+	Start   *int `yaml:"start"`
+	Step    int  `yaml:"step"`
+	Padding int  `yaml:"padding"`
+
+	// uuid
+	Version int `yaml:"version"`
+
+	// random_string
+	Length   int    `yaml:"length"`
+	Alphabet string `yaml:"alphabet"`
+
+	// env
+	Var     string `yaml:"var"`
+	Default string `yaml:"default"`
+
+	// file (trim applies too)
+	Path    string `yaml:"path"`
+	MaxSize int    `yaml:"max_size"`
+
+	// system
+	Info string `yaml:"info"`
+
+	// calc
+	Expr      string `yaml:"expr"`
+	Precision *int   `yaml:"precision"`
+
+	// script (timeout is shared with shell)
+	Script script `yaml:"script"`
+
+	// plugin (timeout is shared with shell)
+	Plugin string            `yaml:"plugin"`
+	Args   map[string]string `yaml:"args"`
 }
 
 // MatchDef is the raw YAML representation of a match entry.
 type MatchDef struct {
-	Trigger  string   `yaml:"trigger"`
-	Triggers []string `yaml:"triggers"`
-	Replace  string   `yaml:"replace"`
-	Vars     []VarDef `yaml:"vars"`
+	Trigger     string   `yaml:"trigger"`
+	Triggers    []string `yaml:"triggers"`
+	Replace     string   `yaml:"replace"`
+	Replaces    []string `yaml:"replaces"`
+	Choice      string   `yaml:"choice"`
+	Vars        []VarDef `yaml:"vars"`
+	TriggerMode string   `yaml:"trigger_mode"`
+	Word        bool     `yaml:"word"`
+	LeftWord    bool     `yaml:"left_word"`
+	RightWord   bool     `yaml:"right_word"`
+
+	PropagateCase  bool   `yaml:"propagate_case"`
+	UppercaseStyle string `yaml:"uppercase_style"`
+
+	Regex string `yaml:"regex"`
+
+	// line is the match's line in its file, for error messages.
+	line int
+}
+
+// UnmarshalYAML decodes a match entry and records its line number.
+func (md *MatchDef) UnmarshalYAML(node *yaml.Node) error {
+	type plain MatchDef
+	if err := node.Decode((*plain)(md)); err != nil {
+		return err
+	}
+	md.line = node.Line
+	return nil
 }
 
 // Match is a resolved, single-trigger match ready for the expander.
+// TriggerMode is always set: the match's own mode, else its file's, else
+// the global one. LeftWord/RightWord require the trigger to start/end on a
+// word separator ("word: true" sets both). PropagateCase matches have a
+// lowercase Trigger matched case-insensitively, and carry the typed case
+// over to the replacement. Regex matches have no Trigger; Regex is
+// anchored to the end of the buffer and its named groups become variables.
+// A match with Replaces picks one of them per expansion instead of Replace.
 type Match struct {
-	Trigger    string
-	Replace    string
-	Vars       []VarDef
-	GlobalVars []VarDef
+	Trigger        string
+	Regex          *regexp.Regexp
+	Replace        string
+	Replaces       []string
+	Choice         string
+	Vars           []VarDef
+	GlobalVars     []VarDef
+	TriggerMode    string
+	LeftWord       bool
+	RightWord      bool
+	PropagateCase  bool
+	UppercaseStyle string
+
+	// fingerprint identifies the match definition across reloads, to
+	// keep its round-robin position while it is unchanged.
+	fingerprint string
+
+	// pos is the match's file:line, for error messages.
+	pos string
+}
+
+// Trigger modes.
+const (
+	TriggerModeSpace     = "space"
+	TriggerModeImmediate = "immediate"
+)
+
+// hasMode reports whether m fires in the given trigger mode. In immediate
+// mode rightWord selects the right_word matches or the rest.
+func (m *Match) hasMode(mode string, rightWord bool) bool {
+	if m.TriggerMode != mode {
+		return false
+	}
+	return mode != TriggerModeImmediate || m.RightWord == rightWord
 }
 
-// Config holds all loaded matches and the global trigger mode.
+// compileTriggerRegex compiles a regex trigger anchored to the end of the
+// typing buffer. Patterns that match empty text are rejected since they
+// would fire on every keystroke.
+func compileTriggerRegex(pattern string) (*regexp.Regexp, error) {
+	// Compile the pattern alone first so errors quote what the user wrote.
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile("(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("pattern matches empty text")
+	}
+	return re, nil
+}
+
+// validTriggerMode reports whether mode is a known trigger mode or empty
+// (inherit).
+func validTriggerMode(mode string) bool {
+	return mode == "" || mode == TriggerModeSpace || mode == TriggerModeImmediate
+}
+
+// Config holds all loaded matches, the global trigger mode (the default
+// for matches that don't set their own) and the keymap used to decode and
+// type text.
 type Config struct {
 	TriggerMode string
 	Matches     []Match
+	Keymap      *Keymap
+
+	// wordSeparators is the set of runes that delimit words.
+	wordSeparators map[rune]bool
+
+	// RegexWindow is how many bytes of recent typing regex matches see.
+	RegexWindow int
+
+	// triggerChars and triggerKeyCodes are the keys that fire "space"
+	// mode matches; triggerKeyCodes maps Tab/Enter to the text they type.
+	triggerChars    map[rune]bool
+	triggerKeyCodes map[evdev.EvCode]string
+
+	// undoBackspace lets Backspace right after an expansion undo it;
+	// undoSuppress then keeps the match from firing until the buffer
+	// resets.
+	undoBackspace bool
+	undoSuppress  bool
+
+	// locale is the default locale of date variables.
+	locale string
+
+	// dir is the config directory, for file variables.
+	dir string
+
+	// calcMatch is the built-in =expr= match, nil unless the calculator
+	// is enabled; calcPrecision is the default number of decimals of
+	// calculator results.
+	calcMatch     *Match
+	calcPrecision int
+
+	// hasImmediate is true if any match uses "immediate" mode, so the
+	// expander can skip per-keystroke lookups otherwise.
+	hasImmediate bool
+
+	// regexMatches indexes the matches with a regex trigger.
+	regexMatches []int
+
+	// byTrigger indexes the first match for each trigger, for match
+	// variables.
+	byTrigger map[string]int
 }
 
 // LoadAppConfig reads config.yml from the given config directory.
 // Returns a default config (trigger_mode: space) if the file doesn't exist.
 func LoadAppConfig(dir string) (*AppConfig, error) {
-	cfg := &AppConfig{TriggerMode: "space"}
+	cfg := &AppConfig{
+		TriggerMode:    TriggerModeSpace,
+		WordSeparators: defaultWordSeparators,
+		RegexWindow:    defaultRegexWindow,
+		TriggerKeys:    []string{"space"},
+		UndoBackspace:  true,
+
+		CalculatorPrecision: defaultCalcPrecision,
+	}
 
 	data, err := os.ReadFile(filepath.Join(dir, "config.yml"))
 	if err != nil {
@@ -72,12 +330,39 @@ func LoadAppConfig(dir string) (*AppConfig, error) {
 	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("parse config.yml: %w", err)
 	}
+	if cfg.TriggerMode == "" {
+		cfg.TriggerMode = TriggerModeSpace
+	}
+	if !validTriggerMode(cfg.TriggerMode) {
+		return nil, fmt.Errorf("config.yml: invalid trigger_mode %q", cfg.TriggerMode)
+	}
+	for _, sep := range cfg.WordSeparators {
+		if utf8.RuneCountInString(sep) != 1 {
+			return nil, fmt.Errorf("config.yml: word_separators entry %q must be a single character", sep)
+		}
+	}
+	if cfg.RegexWindow <= 0 {
+		return nil, fmt.Errorf("config.yml: regex_window must be positive, got %d", cfg.RegexWindow)
+	}
+	if _, _, err := parseTriggerKeys(cfg.TriggerKeys); err != nil {
+		return nil, fmt.Errorf("config.yml: %w", err)
+	}
+	if _, err := lookupLocale(cfg.Locale); err != nil {
+		return nil, fmt.Errorf("config.yml: %w", err)
+	}
+	if cfg.CalculatorPrecision < 0 || cfg.CalculatorPrecision > maxCalcPrecision {
+		return nil, fmt.Errorf("config.yml: calculator_precision must be between 0 and %d, got %d", maxCalcPrecision, cfg.CalculatorPrecision)
+	}
+	if err := validatePluginDefs(cfg.Plugins); err != nil {
+		return nil, fmt.Errorf("config.yml: %w", err)
+	}
 
 	return cfg, nil
 }
 
 // LoadConfig reads all YAML files from dir/match/ and returns a Config
-// with matches sorted longest-trigger-first.
+// with matches in file and declaration order. Trigger precedence (longest
+// first) is handled by the expander's trigger index.
 func LoadConfig(dir string, appCfg *AppConfig) (*Config, error) {
 	matchDir := filepath.Join(dir, "match")
 	files, err := filepath.Glob(filepath.Join(matchDir, "*.yml"))
@@ -86,6 +371,9 @@ func LoadConfig(dir string, appCfg *AppConfig) (*Config, error) {
 	}
 
 	var allMatches []Match
+	// matchRefs are the match variables, checked once all triggers are
+	// known.
+	var matchRefs []matchRef
 
 	for _, f := range files {
 		data, err := os.ReadFile(f)
@@ -97,31 +385,335 @@ func LoadConfig(dir string, appCfg *AppConfig) (*Config, error) {
 		if err := yaml.Unmarshal(data, &cf); err != nil {
 			return nil, fmt.Errorf("parse %s: %w", f, err)
 		}
+		if !validTriggerMode(cf.TriggerMode) {
+			return nil, fmt.Errorf("%s: invalid trigger_mode %q", f, cf.TriggerMode)
+		}
+		// Like a bad template, an unknown variable type only disables what
+		// uses it.
+		if err := validateVars(cf.GlobalVars); err != nil {
+			if !errors.Is(err, errUnknownVarType) {
+				return nil, fmt.Errorf("%s: %w", f, err)
+			}
+			fmt.Fprintf(os.Stderr, "texpand: WARNING: %s: %v — skipping this file\n", f, err)
+			continue
+		}
+		if err := checkPluginVars(cf.GlobalVars, appCfg.Plugins); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		globalScope := make(map[string]bool)
+		for _, v := range cf.GlobalVars {
+			globalScope[v.Name] = true
+		}
+		// A bad template only disables what uses it, so a snippet that
+		// happens to contain {{...}} does not take every match down.
+		if err := checkTemplates(cf.GlobalVars, nil, globalScope); err != nil {
+			fmt.Fprintf(os.Stderr, "texpand: WARNING: %s: %v — skipping this file\n", f, err)
+			continue
+		}
+		matchRefs = appendMatchRefs(matchRefs, f, cf.GlobalVars)
+		if err := checkVarCycles(varsByName(cf.GlobalVars, nil)); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		fileMode := cf.TriggerMode
+		if fileMode == "" {
+			fileMode = appCfg.TriggerMode
+		}
 
 		for _, md := range cf.Matches {
+			if !validTriggerMode(md.TriggerMode) {
+				return nil, fmt.Errorf("%s:%d: invalid trigger_mode %q", f, md.line, md.TriggerMode)
+			}
+			mode := md.TriggerMode
+			if mode == "" {
+				mode = fileMode
+			}
+			if !validUppercaseStyle(md.UppercaseStyle) {
+				return nil, fmt.Errorf("%s:%d: invalid uppercase_style %q", f, md.line, md.UppercaseStyle)
+			}
+			if err := validateVars(md.Vars); err != nil {
+				if !errors.Is(err, errUnknownVarType) {
+					return nil, fmt.Errorf("%s:%d: %w", f, md.line, err)
+				}
+				fmt.Fprintf(os.Stderr, "texpand: WARNING: %s:%d: %v — skipping this match\n", f, md.line, err)
+				continue
+			}
+			if err := checkPluginVars(md.Vars, appCfg.Plugins); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", f, md.line, err)
+			}
+			if md.Replace != "" && len(md.Replaces) > 0 {
+				return nil, fmt.Errorf("%s:%d: replace cannot be combined with replaces", f, md.line)
+			}
+			if !validChoice(md.Choice) {
+				return nil, fmt.Errorf("%s:%d: invalid choice %q", f, md.line, md.Choice)
+			}
+
+			var re *regexp.Regexp
+			if md.Regex != "" {
+				if md.Trigger != "" || len(md.Triggers) > 0 {
+					return nil, fmt.Errorf("%s:%d: regex cannot be combined with trigger/triggers", f, md.line)
+				}
+				if md.PropagateCase {
+					return nil, fmt.Errorf("%s:%d: regex cannot be combined with propagate_case", f, md.line)
+				}
+				re, err = compileTriggerRegex(md.Regex)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: regex %q: %w", f, md.line, md.Regex, err)
+				}
+			}
+
+			// Replacements see the global vars, the match's vars and
+			// the regex's named groups.
+			scope := maps.Clone(globalScope)
+			for _, v := range md.Vars {
+				scope[v.Name] = true
+			}
+			if re != nil {
+				for _, name := range re.SubexpNames()[1:] {
+					if name != "" {
+						scope[name] = true
+					}
+				}
+			}
+			if err := checkTemplates(md.Vars, append([]string{md.Replace}, md.Replaces...), scope); err != nil {
+				fmt.Fprintf(os.Stderr, "texpand: WARNING: %s:%d: %v — skipping this match\n", f, md.line, err)
+				continue
+			}
+			if err := checkVarCycles(varsByName(cf.GlobalVars, md.Vars)); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", f, md.line, err)
+			}
+			matchRefs = appendMatchRefs(matchRefs, fmt.Sprintf("%s:%d", f, md.line), md.Vars)
+
+			fingerprint, err := json.Marshal(struct {
+				Match      MatchDef
+				GlobalVars []VarDef
+			}{md, cf.GlobalVars})
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", f, md.line, err)
+			}
+
+			base := Match{
+				Regex:       re,
+				Replace:     md.Replace,
+				Replaces:    md.Replaces,
+				Choice:      md.Choice,
+				Vars:        md.Vars,
+				GlobalVars:  cf.GlobalVars,
+				TriggerMode: mode,
+				LeftWord:    md.Word || md.LeftWord,
+				RightWord:   md.Word || md.RightWord,
+
+				PropagateCase:  md.PropagateCase,
+				UppercaseStyle: md.UppercaseStyle,
+
+				fingerprint: string(fingerprint),
+				pos:         fmt.Sprintf("%s:%d", f, md.line),
+			}
+
+			if re != nil {
+				allMatches = append(allMatches, base)
+				continue
+			}
+
 			triggers := []string{md.Trigger}
 			if len(md.Triggers) > 0 {
 				triggers = md.Triggers
 			}
-
 			for _, t := range triggers {
 				if t == "" {
 					continue
 				}
-				allMatches = append(allMatches, Match{
-					Trigger:    t,
-					Replace:    md.Replace,
-					Vars:       md.Vars,
-					GlobalVars: cf.GlobalVars,
-				})
+				// Typed triggers are composed (e.g. dead key + letter),
+				// so compare against the NFC form.
+				t = norm.NFC.String(t)
+				if md.PropagateCase {
+					t = strings.ToLower(t)
+				}
+				m := base
+				m.Trigger = t
+				allMatches = append(allMatches, m)
 			}
 		}
 	}
 
-	// Longest trigger first to avoid false matches
-	sort.Slice(allMatches, func(i, j int) bool {
-		return len(allMatches[i].Trigger) > len(allMatches[j].Trigger)
-	})
+	keymap, err := LoadKeymap(appCfg.KeyboardLayout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: %v — falling back to US layout\n", err)
+		keymap = usKeymap
+	}
+
+	byTrigger := make(map[string]int)
+	for i, m := range allMatches {
+		if _, ok := byTrigger[m.Trigger]; !ok && m.Trigger != "" {
+			byTrigger[m.Trigger] = i
+		}
+	}
+	for _, ref := range matchRefs {
+		if _, ok := lookupTrigger(byTrigger, ref.trigger); !ok {
+			return nil, fmt.Errorf("%s: var %q: no match with trigger %q", ref.pos, ref.name, ref.trigger)
+		}
+	}
+	if err := checkMatchCycles(allMatches, byTrigger); err != nil {
+		return nil, err
+	}
+
+	hasImmediate := false
+	var regexMatches []int
+	for i, m := range allMatches {
+		if m.TriggerMode == TriggerModeImmediate {
+			hasImmediate = true
+		}
+		if m.Regex != nil {
+			regexMatches = append(regexMatches, i)
+		}
+	}
+
+	// The calculator fires like a match in the global trigger mode; its
+	// result is passed to the replacement as a capture.
+	var calcMatch *Match
+	if appCfg.Calculator {
+		calcMatch = &Match{
+			Replace:     "{{" + calcResult + "}}",
+			TriggerMode: appCfg.TriggerMode,
+			LeftWord:    true,
+			fingerprint: "calculator",
+		}
+		hasImmediate = hasImmediate || calcMatch.TriggerMode == TriggerModeImmediate
+	}
+
+	separators := make(map[rune]bool, len(appCfg.WordSeparators))
+	for _, sep := range appCfg.WordSeparators {
+		r, _ := utf8.DecodeRuneInString(sep)
+		separators[r] = true
+	}
+
+	triggerChars, triggerKeyCodes, err := parseTriggerKeys(appCfg.TriggerKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		TriggerMode:    appCfg.TriggerMode,
+		Matches:        allMatches,
+		Keymap:         keymap,
+		RegexWindow:    appCfg.RegexWindow * utf8.UTFMax,
+		wordSeparators: separators,
+		hasImmediate:   hasImmediate,
+		regexMatches:   regexMatches,
+		byTrigger:      byTrigger,
+
+		triggerChars:    triggerChars,
+		triggerKeyCodes: triggerKeyCodes,
+		undoBackspace:   appCfg.UndoBackspace,
+		undoSuppress:    appCfg.UndoSuppress,
+		locale:          appCfg.Locale,
+		dir:             dir,
+		calcMatch:       calcMatch,
+		calcPrecision:   appCfg.CalculatorPrecision,
+	}, nil
+}
+
+// matchRef is a match variable's reference to another match's trigger.
+type matchRef struct {
+	pos, name, trigger string
+}
+
+// appendMatchRefs appends the match variables among vars, declared at pos.
+func appendMatchRefs(refs []matchRef, pos string, vars []VarDef) []matchRef {
+	for _, v := range vars {
+		if v.Type == "match" {
+			refs = append(refs, matchRef{pos: pos, name: v.Name, trigger: v.Params.Trigger})
+		}
+	}
+	return refs
+}
+
+// checkMatchCycles reports match variables that lead back to the match
+// using them, or nest more than maxMatchDepth matches deep. Only the
+// variables a match's replacements need are followed, as in ResolveVars.
+func checkMatchCycles(matches []Match, byTrigger map[string]int) error {
+	uses := make([][]int, len(matches))
+	for i, m := range matches {
+		var refs []string
+		for _, t := range append([]string{m.Replace}, m.Replaces...) {
+			refs = append(refs, templateRefs(t)...)
+		}
+		order, _ := resolveOrder(varsByName(m.GlobalVars, m.Vars), refs) // var cycles are reported per file
+		for _, v := range order {
+			if v.Type != "match" || m.Regex != nil && m.Regex.SubexpIndex(v.Name) >= 0 {
+				continue
+			}
+			if j, ok := lookupTrigger(byTrigger, v.Params.Trigger); ok {
+				uses[i] = append(uses[i], j)
+			}
+		}
+	}
+
+	// depth is the longest chain of matches each match expands, 0 until
+	// it is known.
+	depth := make([]int, len(matches))
+	var path []int
+	var visit func(i int) error
+	visit = func(i int) error {
+		if depth[i] > 0 {
+			return nil
+		}
+		if j := slices.Index(path, i); j >= 0 {
+			var triggers []string
+			for _, k := range append(path[j:], i) {
+				triggers = append(triggers, strconv.Quote(matches[k].Trigger))
+			}
+			return fmt.Errorf("%s: match variable cycle: %s", matches[i].pos, strings.Join(triggers, " → "))
+		}
+		path = append(path, i)
+		d := 1
+		for _, j := range uses[i] {
+			if err := visit(j); err != nil {
+				return err
+			}
+			d = max(d, 1+depth[j])
+		}
+		path = path[:len(path)-1]
+		if d > maxMatchDepth {
+			return fmt.Errorf("%s: match variables nested deeper than %d", matches[i].pos, maxMatchDepth)
+		}
+		depth[i] = d
+		return nil
+	}
+	for i := range matches {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupTrigger finds the match for a trigger as written in a config
+// file, which may be a case-insensitive match's lowercased trigger.
+func lookupTrigger(byTrigger map[string]int, trigger string) (int, bool) {
+	trigger = norm.NFC.String(trigger)
+	if i, ok := byTrigger[trigger]; ok {
+		return i, true
+	}
+	i, ok := byTrigger[strings.ToLower(trigger)]
+	return i, ok
+}
+
+// matchByTrigger returns the match a match variable refers to.
+func (c *Config) matchByTrigger(trigger string) (*Match, bool) {
+	i, ok := lookupTrigger(c.byTrigger, trigger)
+	if !ok {
+		return nil, false
+	}
+	return &c.Matches[i], true
+}
+
+// isWordSeparator reports whether r delimits words.
+func (c *Config) isWordSeparator(r rune) bool {
+	return c.wordSeparators[r]
+}
 
-	return &Config{TriggerMode: appCfg.TriggerMode, Matches: allMatches}, nil
+// isTriggerChar reports whether typing ch fires "space" mode matches.
+func (c *Config) isTriggerChar(ch string) bool {
+	r, size := utf8.DecodeRuneInString(ch)
+	return size > 0 && size == len(ch) && c.triggerChars[r]
 }
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	evdev "github.com/holoplot/go-evdev"
+)
+
+// writeTestConfig writes a config directory made of files, with paths
+// relative to it (config.yml, match/*.yml), and loads it.
+func writeTestConfig(t testing.TB, files map[string]string) (*Config, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "match"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appCfg, err := LoadAppConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	return LoadConfig(dir, appCfg)
+}
+
+// loadTestConfig is writeTestConfig for configs that must load.
+func loadTestConfig(t testing.TB, files map[string]string) *Config {
+	t.Helper()
+	cfg, err := writeTestConfig(t, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   string
+		wantErr string
+	}{
+		{
+			"regex with propagate_case",
+			`  - regex: "'x(\\d+)"
+    replace: "y"
+    propagate_case: true
+`,
+			"m.yml:2: regex cannot be combined with propagate_case",
+		},
+		{
+			"regex with trigger",
+			`  - regex: "'x"
+    trigger: "'x"
+    replace: "y"
+`,
+			"m.yml:2: regex cannot be combined with trigger/triggers",
+		},
+		{
+			"invalid regex",
+			`  - trigger: "'ok"
+    replace: "ok"
+  - regex: "'x(\\d+"
+    replace: "y"
+`,
+			`m.yml:4: regex "'x(\\d+": error parsing regexp: missing closing )`,
+		},
+		{
+			"regex matching empty text",
+			`  - regex: "x*"
+    replace: "y"
+`,
+			`m.yml:2: regex "x*": pattern matches empty text`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := writeTestConfig(t, map[string]string{"match/m.yml": "matches:\n" + tt.match})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// matchVarChain returns n matches 'm0 … 'm<n-1>, each but the last
+// expanding the next through a match variable.
+func matchVarChain(n int) string {
+	var b strings.Builder
+	b.WriteString("matches:\n")
+	for i := range n {
+		if i == n-1 {
+			fmt.Fprintf(&b, "  - trigger: \"'m%d\"\n    replace: \"end\"\n", i)
+			continue
+		}
+		fmt.Fprintf(&b, "  - trigger: \"'m%d\"\n    replace: \"{{next}}\"\n    vars:\n      - name: next\n        type: match\n        params: {trigger: \"'m%d\"}\n", i, i+1)
+	}
+	return b.String()
+}
+
+func TestLoadConfigMatchCycles(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   string
+		wantErr string
+	}{
+		{
+			"two matches",
+			`matches:
+  - trigger: "'a"
+    replace: "a{{b}}"
+    vars:
+      - {name: b, type: match, params: {trigger: "'b"}}
+  - trigger: "'b"
+    replace: "b{{a}}"
+    vars:
+      - {name: a, type: match, params: {trigger: "'a"}}
+`,
+			`m.yml:2: match variable cycle: "'a" → "'b" → "'a"`,
+		},
+		{
+			"self",
+			`matches:
+  - trigger: "'a"
+    replace: "a{{a}}"
+    vars:
+      - {name: a, type: match, params: {trigger: "'A"}}
+`,
+			`m.yml:2: match variable cycle: "'a" → "'a"`,
+		},
+		{
+			"through a global var",
+			`global_vars:
+  - {name: sig, type: match, params: {trigger: "'sig"}}
+matches:
+  - trigger: "'sig"
+    replace: "{{greeting}}"
+    vars:
+      - {name: greeting, type: random, params: {choices: ["hi {{sig}}"]}}
+`,
+			`m.yml:4: match variable cycle: "'sig" → "'sig"`,
+		},
+		{
+			"unused variable",
+			`matches:
+  - trigger: "'a"
+    replace: "a"
+    vars:
+      - {name: a, type: match, params: {trigger: "'a"}}
+`,
+			"",
+		},
+		{
+			"global var the target does not use",
+			`global_vars:
+  - {name: sig, type: match, params: {trigger: "'sig"}}
+matches:
+  - trigger: "'sig"
+    replace: "regards"
+  - trigger: "'mail"
+    replace: "hi, {{sig}}"
+`,
+			"",
+		},
+		{"deepest chain", matchVarChain(maxMatchDepth), ""},
+		{"chain too deep", matchVarChain(maxMatchDepth + 1), "m.yml:2: match variables nested deeper than 8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := writeTestConfig(t, map[string]string{"match/m.yml": tt.match})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	appCfg, err := LoadAppConfig("defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig("defaults", appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Matches) == 0 {
+		t.Error("no default matches loaded")
+	}
+}
+
+func TestParseTriggerKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []string
+		wantChars string
+		wantCodes map[evdev.EvCode]string
+		wantErr   string
+	}{
+		{"none", nil, "", map[evdev.EvCode]string{}, ""},
+		{"space", []string{"space"}, " ", map[evdev.EvCode]string{}, ""},
+		{"punctuation", []string{"punctuation"}, ".,;:!?", map[evdev.EvCode]string{}, ""},
+		{"single characters", []string{"-", "é"}, "-é", map[evdev.EvCode]string{}, ""},
+		{"tab", []string{"tab"}, "", map[evdev.EvCode]string{evdev.KEY_TAB: "\t"}, ""},
+		{"enter", []string{"\n"}, "", map[evdev.EvCode]string{evdev.KEY_ENTER: "\n", evdev.KEY_KPENTER: "\n"}, ""},
+		{"unknown name", []string{"space", "escape"}, "", nil, `invalid trigger_keys entry "escape"`},
+		{"empty entry", []string{""}, "", nil, `invalid trigger_keys entry ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chars, codes, err := parseTriggerKeys(tt.keys)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := make(map[rune]bool)
+			for _, r := range tt.wantChars {
+				want[r] = true
+			}
+			if !maps.Equal(chars, want) {
+				t.Errorf("chars = %v, want %v", chars, want)
+			}
+			if !maps.Equal(codes, tt.wantCodes) {
+				t.Errorf("codes = %v, want %v", codes, tt.wantCodes)
+			}
+		})
+	}
+}
+
+func TestTriggerModeInheritance(t *testing.T) {
+	cfg := loadTestConfig(t, map[string]string{
+		"config.yml": "trigger_mode: space\n",
+		"match/immediate.yml": `trigger_mode: immediate
+matches:
+  - trigger: "'i"
+    replace: "inherited"
+  - trigger: "'s"
+    replace: "overridden"
+    trigger_mode: space
+`,
+		"match/plain.yml": `matches:
+  - trigger: "'g"
+    replace: "global"
+  - trigger: "'o"
+    replace: "overridden"
+    trigger_mode: immediate
+`,
+	})
+	for trigger, want := range map[string]string{
+		"'i": TriggerModeImmediate,
+		"'s": TriggerModeSpace,
+		"'g": TriggerModeSpace,
+		"'o": TriggerModeImmediate,
+	} {
+		m, ok := cfg.matchByTrigger(trigger)
+		if !ok {
+			t.Fatalf("%s: not loaded", trigger)
+		}
+		if m.TriggerMode != want {
+			t.Errorf("%s: trigger_mode = %q, want %q", trigger, m.TriggerMode, want)
+		}
+	}
+}
+
+func TestDefaultAccentsImmediate(t *testing.T) {
+	appCfg, err := LoadAppConfig("defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig("defaults", appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trigger := range []string{"]a", "~o", "]A"} {
+		m, ok := cfg.matchByTrigger(trigger)
+		if !ok {
+			t.Fatalf("%s: not loaded", trigger)
+		}
+		if m.TriggerMode != TriggerModeImmediate {
+			t.Errorf("%s: trigger_mode = %q, want %q", trigger, m.TriggerMode, TriggerModeImmediate)
+		}
+	}
+}
+
+func TestLoadConfigUnknownVarType(t *testing.T) {
+	cfg := loadTestConfig(t, map[string]string{
+		"match/m.yml": `matches:
+  - trigger: "'x"
+    replace: "{{out}}"
+    vars:
+      - {name: out, type: shel, params: {cmd: "echo hi"}}
+  - trigger: "'y"
+    replace: "y"
+`,
+		"match/global.yml": `global_vars:
+  - {name: e, type: echo, params: {echo: hi}}
+matches:
+  - trigger: "'z"
+    replace: "z"
+`,
+	})
+	for trigger, want := range map[string]bool{"'x": false, "'y": true, "'z": false} {
+		if _, ok := cfg.matchByTrigger(trigger); ok != want {
+			t.Errorf("%s loaded = %v, want %v", trigger, ok, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// countersFile is the state file holding the last value of each counter
+// variable, by name.
+const countersFile = "counters.json"
+
+// validateCounterVar checks a counter variable's params at load time.
+func validateCounterVar(p VarParams) error {
+	if p.Padding < 0 {
+		return fmt.Errorf("padding must not be negative, got %d", p.Padding)
+	}
+	if p.Format != "" {
+		if s := fmt.Sprintf(p.Format, 1); strings.Contains(s, "%!") {
+			return fmt.Errorf("invalid format %q: needs one integer verb such as %%d", p.Format)
+		}
+	}
+	return nil
+}
+
+// resolveCounter advances the named counter and returns its new value,
+// formatted with format (a printf pattern such as "INV-%05d") or
+// zero-padded to padding digits. The first value is start (default 1); each
+// use adds step (default 1). The state file is replaced atomically so a crash never
+// leaves it half-written.
+func resolveCounter(name string, p VarParams) string {
+	path := filepath.Join(stateDir(), countersFile)
+	counters := make(map[string]int)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &counters)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: counter %q: read %s: %v\n", name, path, err)
+		return ""
+	}
+
+	value, ok := counters[name]
+	switch {
+	case !ok && p.Start != nil:
+		value = *p.Start
+	case !ok:
+		value = 1
+	case p.Step != 0:
+		value += p.Step
+	default:
+		value++
+	}
+	counters[name] = value
+
+	if err := writeFileAtomic(path, counters); err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: counter %q: %v\n", name, err)
+	}
+
+	if p.Format != "" {
+		return fmt.Sprintf(p.Format, value)
+	}
+	s := strconv.Itoa(value)
+	if len(s) < p.Padding {
+		s = strings.Repeat("0", p.Padding-len(s)) + s
+	}
+	return s
+}
+
+// writeFileAtomic writes v as JSON to a temporary file next to path and
+// renames it into place.
+func writeFileAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
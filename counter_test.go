@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCounterVar(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  VarParams
+		wantErr string
+	}{
+		{"defaults", VarParams{}, ""},
+		{"format", VarParams{Format: "INV-%05d"}, ""},
+		{"format without a verb", VarParams{Format: "INV"}, "invalid format"},
+		{"format with a string verb", VarParams{Format: "%s"}, "invalid format"},
+		{"negative padding", VarParams{Padding: -1}, "padding must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCounterVar(tt.params)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveCounter(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	start := 7
+
+	tests := []struct {
+		name   string
+		params VarParams
+		want   []string
+	}{
+		{"plain", VarParams{}, []string{"1", "2", "3"}},
+		{"start and step", VarParams{Start: &start, Step: 5}, []string{"7", "12", "17"}},
+		{"padding", VarParams{Padding: 3}, []string{"001", "002"}},
+		{"format", VarParams{Format: "INV-%04d"}, []string{"INV-0001", "INV-0002"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				if got := resolveCounter(tt.name, tt.params); got != want {
+					t.Errorf("use %d: got %q, want %q", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRandomStringVar(t *testing.T) {
+	for _, length := range []int{0, 1, maxRandomStringLength} {
+		if err := validateRandomStringVar(VarParams{Length: length}); err != nil {
+			t.Errorf("length %d: unexpected error: %v", length, err)
+		}
+	}
+	for _, length := range []int{-1, maxRandomStringLength + 1} {
+		err := validateRandomStringVar(VarParams{Length: length})
+		if err == nil || !strings.Contains(err.Error(), "0 for the default") {
+			t.Errorf("length %d: got error %v, want one explaining the default", length, err)
+		}
+	}
+	if got := resolveRandomString("pw", VarParams{}); len(got) != defaultRandomStringLength {
+		t.Errorf("length 0: got %d characters, want %d", len(got), defaultRandomStringLength)
+	}
+}
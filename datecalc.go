@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// Embedded so time zones work on systems without zoneinfo files.
+	_ "time/tzdata"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dateOffset is a date variable's offset: a plain number of seconds, or
+// calendar units such as "+1d", "-2w" or "+1mo 2h". Units inherit the sign
+// of the previous one, so "-1d12h" is a day and a half back.
+type dateOffset struct {
+	years, months, weeks, days int
+	dur                        time.Duration
+	raw                        string
+}
+
+// UnmarshalYAML parses an offset at load time.
+func (o *dateOffset) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := parseDateOffset(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*o = parsed
+	return nil
+}
+
+// MarshalText returns the offset as written, so match fingerprints see it.
+func (o dateOffset) MarshalText() ([]byte, error) {
+	return []byte(o.raw), nil
+}
+
+// dateTimezone is a date variable's IANA time zone, loaded once at load
+// time. A nil loc means the local time zone.
+type dateTimezone struct {
+	loc  *time.Location
+	name string
+}
+
+// UnmarshalYAML loads a time zone at load time.
+func (z *dateTimezone) UnmarshalYAML(node *yaml.Node) error {
+	if node.Value == "" {
+		*z = dateTimezone{}
+		return nil
+	}
+	loc, err := time.LoadLocation(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: unknown timezone %q", node.Line, node.Value)
+	}
+	*z = dateTimezone{loc: loc, name: node.Value}
+	return nil
+}
+
+// MarshalText returns the time zone as written, so match fingerprints see
+// it.
+func (z dateTimezone) MarshalText() ([]byte, error) {
+	return []byte(z.name), nil
+}
+
+// parseDateOffset parses an offset. Units are s, m/min, h, d, w, mo and y.
+func parseDateOffset(s string) (dateOffset, error) {
+	o := dateOffset{raw: s}
+	if secs, err := strconv.Atoi(s); err == nil {
+		o.dur = time.Duration(secs) * time.Second
+		return o, nil
+	}
+
+	rest := strings.ReplaceAll(s, " ", "")
+	if rest == "" {
+		return o, fmt.Errorf("empty offset")
+	}
+	sign := 1
+	for rest != "" {
+		switch rest[0] {
+		case '+':
+			sign, rest = 1, rest[1:]
+		case '-':
+			sign, rest = -1, rest[1:]
+		}
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		j := i
+		for j < len(rest) && rest[j] >= 'a' && rest[j] <= 'z' {
+			j++
+		}
+		if i == 0 || j == i {
+			return o, fmt.Errorf("invalid offset %q", s)
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return o, fmt.Errorf("invalid offset %q: %w", s, err)
+		}
+		n *= sign
+		switch unit := rest[i:j]; unit {
+		case "s":
+			o.dur += time.Duration(n) * time.Second
+		case "m", "min":
+			o.dur += time.Duration(n) * time.Minute
+		case "h":
+			o.dur += time.Duration(n) * time.Hour
+		case "d":
+			o.days += n
+		case "w":
+			o.weeks += n
+		case "mo":
+			o.months += n
+		case "y":
+			o.years += n
+		default:
+			return o, fmt.Errorf("invalid offset %q: unknown unit %q", s, unit)
+		}
+		rest = rest[j:]
+	}
+	return o, nil
+}
+
+// Snap targets for date variables.
+var dateSnaps = map[string]bool{
+	"start_of_week": true, "end_of_week": true,
+	"start_of_month": true, "end_of_month": true,
+	"start_of_year": true, "end_of_year": true,
+}
+
+// weekdayNames maps English weekday names to time.Weekday.
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+	"wednesday": time.Wednesday, "thursday": time.Thursday,
+	"friday": time.Friday, "saturday": time.Saturday,
+}
+
+// parseWeekday parses a weekday param: "monday" (today or the next one),
+// "next monday" (strictly after today) or "last monday" (strictly before).
+func parseWeekday(s string) (rel string, day time.Weekday, err error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 2 && (fields[0] == "next" || fields[0] == "last" || fields[0] == "this") {
+		rel, fields = fields[0], fields[1:]
+	}
+	if len(fields) == 1 {
+		if d, ok := weekdayNames[fields[0]]; ok {
+			return rel, d, nil
+		}
+	}
+	return "", 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+// validateDateVar checks a date variable's params at load time.
+func validateDateVar(p VarParams) error {
+	if p.Weekday != "" {
+		if _, _, err := parseWeekday(p.Weekday); err != nil {
+			return err
+		}
+	}
+	if p.Snap != "" && !dateSnaps[p.Snap] {
+		return fmt.Errorf("invalid snap %q", p.Snap)
+	}
+	return nil
+}
+
+// dateFor computes a date variable's time from now, in its timezone if
+// set, with calendar arithmetic so day-based offsets keep the wall-clock
+// time across DST changes. The offset applies first, then weekday, then snap. With
+// skip_weekends, day offsets count weekdays only, a week being five of
+// them, and a result on a weekend moves to the next Monday (the previous
+// Friday for end_* snaps and negative offsets).
+func dateFor(now time.Time, p VarParams) time.Time {
+	if p.Timezone.loc != nil {
+		now = now.In(p.Timezone.loc)
+	}
+	o := p.Offset
+	t := addMonths(now, 12*o.years+o.months)
+	if p.SkipWeekends {
+		t = addWeekdays(t, 5*o.weeks+o.days)
+	} else {
+		t = t.AddDate(0, 0, 7*o.weeks+o.days)
+	}
+	t = t.Add(o.dur)
+
+	if p.Weekday != "" {
+		rel, day, _ := parseWeekday(p.Weekday)
+		diff := (int(day) - int(t.Weekday()) + 7) % 7
+		switch {
+		case rel == "next" && diff == 0:
+			diff = 7
+		case rel == "last":
+			diff -= 7
+		}
+		t = t.AddDate(0, 0, diff)
+	}
+
+	switch p.Snap {
+	case "start_of_week":
+		t = t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+	case "end_of_week":
+		t = t.AddDate(0, 0, (7-int(t.Weekday()))%7)
+	case "start_of_month":
+		t = t.AddDate(0, 0, 1-t.Day())
+	case "end_of_month":
+		t = t.AddDate(0, 1, -t.Day())
+	case "start_of_year":
+		t = t.AddDate(0, 0, 1-t.YearDay())
+	case "end_of_year":
+		t = time.Date(t.Year(), time.December, 31, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	}
+
+	if p.SkipWeekends {
+		step := 1
+		if o.days < 0 || strings.HasPrefix(p.Snap, "end_") {
+			step = -1
+		}
+		for isWeekend(t) {
+			t = t.AddDate(0, 0, step)
+		}
+	}
+	return t
+}
+
+// addMonths moves t by n months, clamping the day to the end of a shorter
+// month (Jan 31 + 1mo is the last day of February).
+func addMonths(t time.Time, n int) time.Time {
+	if n == 0 {
+		return t
+	}
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), last)-1)
+}
+
+// addWeekdays moves t by n days, counting Monday to Friday only.
+func addWeekdays(t time.Time, n int) time.Time {
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for n > 0 {
+		t = t.AddDate(0, 0, step)
+		if !isWeekend(t) {
+			n--
+		}
+	}
+	return t
+}
+
+// isWeekend reports whether t falls on a Saturday or Sunday.
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDateTimezoneYAML(t *testing.T) {
+	var p VarParams
+	if err := yaml.Unmarshal([]byte("timezone: America/New_York"), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Timezone.loc == nil || p.Timezone.loc.String() != "America/New_York" {
+		t.Errorf("loc = %v, want America/New_York", p.Timezone.loc)
+	}
+
+	p = VarParams{}
+	if err := yaml.Unmarshal([]byte(`timezone: ""`), &p); err != nil || p.Timezone.loc != nil {
+		t.Errorf("empty timezone = %v, %v; want local", p.Timezone.loc, err)
+	}
+
+	err := yaml.Unmarshal([]byte("\ntimezone: Mars/Olympus"), &p)
+	if err == nil || !strings.Contains(err.Error(), `line 2: unknown timezone "Mars/Olympus"`) {
+		t.Errorf("error = %v, want unknown timezone on line 2", err)
+	}
+}
+
+func TestDateForTimezone(t *testing.T) {
+	var p VarParams
+	if err := yaml.Unmarshal([]byte("timezone: Europe/Lisbon\noffset: +1d"), &p); err != nil {
+		t.Fatal(err)
+	}
+	// The day before the October DST change: a day later is the same
+	// wall-clock time, 25 hours on.
+	now := time.Date(2026, 10, 24, 11, 0, 0, 0, time.UTC)
+	got := dateFor(now, p)
+	if want := "2026-10-25 12:00 WET"; got.Format("2006-01-02 15:04 MST") != want {
+		t.Errorf("dateFor = %s, want %s", got.Format("2006-01-02 15:04 MST"), want)
+	}
+}
+
+func TestParseDateOffset(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    dateOffset
+		wantErr string
+	}{
+		{"3600", dateOffset{dur: time.Hour}, ""},
+		{"-86400", dateOffset{dur: -24 * time.Hour}, ""},
+		{"+1d", dateOffset{days: 1}, ""},
+		{"-2w", dateOffset{weeks: -2}, ""},
+		{"-1d12h", dateOffset{days: -1, dur: -12 * time.Hour}, ""},
+		{"+1mo-1d", dateOffset{months: 1, days: -1}, ""},
+		{"+1mo 2h", dateOffset{months: 1, dur: 2 * time.Hour}, ""},
+		{"1y-6mo", dateOffset{years: 1, months: -6}, ""},
+		{"90min30s", dateOffset{dur: 90*time.Minute + 30*time.Second}, ""},
+		{"5m", dateOffset{dur: 5 * time.Minute}, ""},
+		{"", dateOffset{}, "empty offset"},
+		{"+d", dateOffset{}, "invalid offset"},
+		{"1", dateOffset{dur: time.Second}, ""},
+		{"1x", dateOffset{}, `unknown unit "x"`},
+		{"1D", dateOffset{}, "invalid offset"},
+	}
+	for _, tt := range tests {
+		got, err := parseDateOffset(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseDateOffset(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		tt.want.raw = tt.in
+		if err != nil || got != tt.want {
+			t.Errorf("parseDateOffset(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestDateFor(t *testing.T) {
+	// 2026-01-14 is a Wednesday.
+	wed := time.Date(2026, 1, 14, 10, 30, 0, 0, time.UTC)
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 10, 30, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		params string
+		want   time.Time
+	}{
+		{"no params", wed, "{}", wed},
+		{"legacy seconds", wed, "offset: 3600", wed.Add(time.Hour)},
+		{"legacy negative seconds", wed, "offset: -86400", day(2026, 1, 13)},
+		{"mixed sign", wed, "offset: -1d12h", time.Date(2026, 1, 12, 22, 30, 0, 0, time.UTC)},
+		{"month then day back", wed, "offset: +1mo-1d", day(2026, 2, 13)},
+		{"weeks", wed, "offset: +2w", day(2026, 1, 28)},
+		{"year", wed, "offset: +1y", day(2027, 1, 14)},
+		{"Jan 31 + 1mo", day(2026, 1, 31), "offset: +1mo", day(2026, 2, 28)},
+		{"Jan 31 + 1mo in a leap year", day(2024, 1, 31), "offset: +1mo", day(2024, 2, 29)},
+		{"Mar 31 - 1mo", day(2026, 3, 31), "offset: -1mo", day(2026, 2, 28)},
+		{"Feb 29 + 1y", day(2024, 2, 29), "offset: +1y", day(2025, 2, 28)},
+
+		{"start_of_week", wed, "snap: start_of_week", day(2026, 1, 12)},
+		{"start_of_week on a Sunday", day(2026, 1, 18), "snap: start_of_week", day(2026, 1, 12)},
+		{"end_of_week", wed, "snap: end_of_week", day(2026, 1, 18)},
+		{"start_of_month", wed, "snap: start_of_month", day(2026, 1, 1)},
+		{"end_of_month", wed, "snap: end_of_month", day(2026, 1, 31)},
+		{"end_of_month after an offset", day(2026, 1, 31), "{offset: +1mo, snap: end_of_month}", day(2026, 2, 28)},
+		{"start_of_year", wed, "snap: start_of_year", day(2026, 1, 1)},
+		{"end_of_year", wed, "snap: end_of_year", day(2026, 12, 31)},
+
+		{"weekday today", wed, "weekday: wednesday", wed},
+		{"next weekday today", wed, "weekday: next wednesday", day(2026, 1, 21)},
+		{"last weekday today", wed, "weekday: last wednesday", day(2026, 1, 7)},
+		{"this weekday today", wed, "weekday: this wednesday", wed},
+		{"weekday later this week", wed, "weekday: friday", day(2026, 1, 16)},
+		{"next weekday later this week", wed, "weekday: next friday", day(2026, 1, 16)},
+		{"last weekday", wed, "weekday: last friday", day(2026, 1, 9)},
+		{"weekday earlier in the week", wed, "weekday: Monday", day(2026, 1, 19)},
+		{"weekday after an offset", wed, "{offset: +1w, weekday: monday}", day(2026, 1, 26)},
+
+		{"skip_weekends over a weekend", day(2026, 1, 16), "{offset: +1d, skip_weekends: true}", day(2026, 1, 19)},
+		{"skip_weekends several days", day(2026, 1, 16), "{offset: +3d, skip_weekends: true}", day(2026, 1, 21)},
+		{"skip_weekends back over a weekend", day(2026, 1, 19), "{offset: -1d, skip_weekends: true}", day(2026, 1, 16)},
+		{"skip_weekends a week back", day(2026, 1, 19), "{offset: -6d, skip_weekends: true}", day(2026, 1, 9)},
+		{"skip_weekends one week", day(2026, 1, 14), "{offset: +1w, skip_weekends: true}", day(2026, 1, 21)},
+		{"skip_weekends a week and a day", day(2026, 1, 16), "{offset: +1w1d, skip_weekends: true}", day(2026, 1, 26)},
+		{"skip_weekends one week back", day(2026, 1, 19), "{offset: -1w, skip_weekends: true}", day(2026, 1, 12)},
+		{"skip_weekends on a Saturday", day(2026, 1, 17), "skip_weekends: true", day(2026, 1, 19)},
+		{"skip_weekends with start_of_month", day(2026, 2, 10), "{snap: start_of_month, skip_weekends: true}", day(2026, 2, 2)},
+		{"skip_weekends with end_of_month", wed, "{snap: end_of_month, skip_weekends: true}", day(2026, 1, 30)},
+		{"skip_weekends with end_of_week", wed, "{snap: end_of_week, skip_weekends: true}", day(2026, 1, 16)},
+		{"skip_weekends with legacy seconds", day(2026, 1, 16), "{offset: 86400, skip_weekends: true}", day(2026, 1, 19)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p VarParams
+			if err := yaml.Unmarshal([]byte(tt.params), &p); err != nil {
+				t.Fatal(err)
+			}
+			if err := validateDateVar(p); err != nil {
+				t.Fatal(err)
+			}
+			if got := dateFor(tt.now, p); !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got.Format(time.RFC1123), tt.want.Format(time.RFC1123))
+			}
+		})
+	}
+}
+
+func TestValidateDateVar(t *testing.T) {
+	for _, p := range []VarParams{{Weekday: "someday"}, {Weekday: "next"}, {Weekday: "previous monday"}, {Snap: "end_of_decade"}} {
+		if err := validateDateVar(p); err == nil {
+			t.Errorf("validateDateVar(%+v) succeeded", p)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+#!/usr/bin/env python3
+"""Sample texpand plugin.
+
+texpand starts this once and keeps it running. Each line on stdin is a
+request such as
+
+    {"id": 1, "method": "resolve", "var": "hash", "args": {"op": "sha256", "text": "hi"}}
+
+and each response is one line on stdout with the same id:
+
+    {"id": 1, "value": "8f434346..."}   or   {"id": 1, "error": "..."}
+
+Declare it in config.yml:
+
+    plugins:
+        - name: sample
+          cmd: python3 plugins/sample.py
+
+and use it from a match:
+
+    - trigger: "'lorem"
+      replace: "{{text}}"
+      vars:
+          - name: text
+            type: plugin
+            params:
+                plugin: sample
+                args:
+                    op: lorem
+                    words: "12"
+"""
+
+import hashlib
+import json
+import sys
+
+LOREM = (
+    "lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod "
+    "tempor incididunt ut labore et dolore magna aliqua"
+).split()
+
+
+def lorem(args):
+    n = int(args.get("words", "8"))
+    words = [LOREM[i % len(LOREM)] for i in range(n)]
+    return " ".join(words).capitalize() + "."
+
+
+def sha256(args):
+    return hashlib.sha256(args.get("text", "").encode()).hexdigest()
+
+
+def upper(args):
+    return args.get("text", "").upper()
+
+
+OPS = {"lorem": lorem, "sha256": sha256, "upper": upper}
+
+
+def handle(req):
+    if req.get("method") != "resolve":
+        raise ValueError("unknown method %r" % req.get("method"))
+    args = req.get("args") or {}
+    op = OPS.get(args.get("op"))
+    if op is None:
+        raise ValueError("unknown op %r for var %r" % (args.get("op"), req.get("var")))
+    return op(args)
+
+
+def main():
+    for line in sys.stdin:
+        if not line.strip():
+            continue
+        req = json.loads(line)
+        try:
+            resp = {"id": req["id"], "value": handle(req)}
+        except Exception as e:  # report every failure to texpand
+            resp = {"id": req["id"], "error": str(e)}
+        # Flush each response: stdout is a pipe, so it is block-buffered.
+        print(json.dumps(resp), flush=True)
+
+
+if __name__ == "__main__":
+    main()
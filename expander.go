@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"maps"
 	"os/exec"
+	"slices"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -11,36 +13,6 @@ import (
 	evdev "github.com/holoplot/go-evdev"
 )
 
-// ReverseKey maps a character to the uinput key code needed to type it,
-// plus whether Shift must be held.
-type ReverseKey struct {
-	Code  int
-	Shift bool
-}
-
-// reverseKeyMap maps runes to their key code + shift state, built from KeyCharMap at init.
-var reverseKeyMap map[rune]ReverseKey
-
-func init() {
-	// evdev key codes are numerically identical to uinput key codes, so we
-	// can cast directly.
-	reverseKeyMap = make(map[rune]ReverseKey, len(KeyCharMap)*2)
-	for evCode, kc := range KeyCharMap {
-		code := int(evCode)
-		for _, r := range kc.Normal {
-			reverseKeyMap[r] = ReverseKey{Code: code, Shift: false}
-		}
-		for _, r := range kc.Shifted {
-			if r != []rune(kc.Normal)[0] { // avoid overwriting if Normal == Shifted (e.g. space)
-				reverseKeyMap[r] = ReverseKey{Code: code, Shift: true}
-			}
-		}
-	}
-	// Special keys not in KeyCharMap
-	reverseKeyMap['\n'] = ReverseKey{Code: uinput.KeyEnter, Shift: false}
-	reverseKeyMap['\t'] = ReverseKey{Code: uinput.KeyTab, Shift: false}
-}
-
 // hasWtype is true if the wtype binary is available on PATH.
 // Checked once at init to avoid repeated lookups.
 var hasWtype bool
@@ -59,74 +31,137 @@ func init() {
 type Expander struct {
 	config *Config
 	vkbd   uinput.Keyboard
+	index  *triggerIndex
 	buf    string
-	shift  bool
 	maxLen int
+
+	// Modifier and lock state, used to decode key presses.
+	shift    bool
+	altGr    bool
+	capsLock bool
+	numLock  bool
+
+	// dead is the combining mark of a pending dead key, or 0.
+	dead rune
+
+	// bufBoundary is true when the start of buf is a word boundary (after
+	// a buffer reset), for left_word matches spanning the whole buffer.
+	bufBoundary bool
+
+	// last is the expansion Backspace undoes if it is the next key, or
+	// nil. suppressed is a match undone with undo_suppress set; it does
+	// not fire again until the buffer resets.
+	last       *lastExpansion
+	suppressed *Match
+
+	// rotation is the round-robin state of replaces lists and random
+	// variables.
+	rotation rotation
+
+	// plugins are the running plugins plugin variables ask.
+	plugins plugins
+}
+
+// lastExpansion records what an expansion typed so it can be undone.
+type lastExpansion struct {
+	match        *Match
+	typed        string // trigger text as typed
+	terminator   string // key typed after the trigger that fired it
+	length       int    // runes typed: replacement plus terminator
+	cursorOffset int    // runes left of the cursor's end position ($|$)
+
+	// buf and bufBoundary are the buffer state before the expansion.
+	buf         string
+	bufBoundary bool
 }
 
 // NewExpander creates an Expander with the given config and virtual keyboard.
 func NewExpander(cfg *Config, vkbd uinput.Keyboard) *Expander {
-	maxLen := 0
-	for _, m := range cfg.Matches {
-		if len(m.Trigger) > maxLen {
-			maxLen = len(m.Trigger)
-		}
-	}
-	return &Expander{config: cfg, vkbd: vkbd, maxLen: maxLen}
+	index := newTriggerIndex(cfg.Matches)
+	return &Expander{config: cfg, vkbd: vkbd, index: index, maxLen: bufferLimit(cfg, index), bufBoundary: true, rotation: make(rotation)}
 }
 
-// Reload swaps the config and recalculates maxLen. Typing session state
-// (buf, shift) is preserved so in-progress typing is not disrupted.
+// Reload swaps the config and rebuilds the trigger index. Typing session
+// state (buf, modifiers, locks) is preserved so in-progress typing is not
+// disrupted, as is the rotation of unchanged matches.
 func (e *Expander) Reload(cfg *Config) {
 	e.config = cfg
-	e.maxLen = 0
-	for _, m := range cfg.Matches {
-		if len(m.Trigger) > e.maxLen {
-			e.maxLen = len(m.Trigger)
-		}
+	e.index = newTriggerIndex(cfg.Matches)
+	e.maxLen = bufferLimit(cfg, e.index)
+	e.trimBuffer()
+	e.last = nil
+	e.suppressed = nil
+	e.rotation.prune(cfg.Matches)
+}
+
+// SetPlugins swaps the running plugins plugin variables ask.
+func (e *Expander) SetPlugins(ps plugins) {
+	e.plugins = ps
+}
+
+// bufferLimit returns how many bytes of typing the buffer must keep: the
+// longest trigger, or the regex look-back window if regex matches exist,
+// or the calculator's window if enabled, whichever is longest.
+func bufferLimit(cfg *Config, index *triggerIndex) int {
+	limit := index.maxLen
+	if len(cfg.regexMatches) > 0 {
+		limit = max(limit, cfg.RegexWindow)
 	}
-	if len(e.buf) > e.maxLen {
-		e.buf = e.buf[len(e.buf)-e.maxLen:]
+	if cfg.calcMatch != nil {
+		limit = max(limit, calcWindow)
 	}
+	return limit
 }
 
 // ResetInputState clears transient keyboard state after a physical keyboard
 // disconnects or reconnects.
 func (e *Expander) ResetInputState() {
-	e.buf = ""
+	e.resetBuffer()
 	e.shift = false
+	e.altGr = false
+	e.dead = 0
+	e.last = nil
 }
 
-// canTypeDirectly returns true if every rune in text has a reverse key mapping.
-func canTypeDirectly(text string) bool {
-	for _, r := range text {
-		if _, ok := reverseKeyMap[r]; !ok {
-			return false
-		}
-	}
-	return true
-}
-
 // typeText types text character-by-character via the virtual keyboard.
 // No inter-key delays — uinput events are kernel-FIFO-ordered.
 func (e *Expander) typeText(text string) {
 	for _, r := range text {
-		rk := reverseKeyMap[r]
-		if rk.Shift {
+		rk := e.config.Keymap.Reverse[r]
+		shift := rk.Shift
+		if e.capsLock && rk.Alphabetic {
+			shift = !shift
+		}
+		if rk.AltGr {
+			e.vkbd.KeyDown(uinput.KeyRightalt)
+		}
+		if shift {
 			e.vkbd.KeyDown(uinput.KeyLeftshift)
 		}
 		e.vkbd.KeyPress(rk.Code)
-		if rk.Shift {
+		if shift {
 			e.vkbd.KeyUp(uinput.KeyLeftshift)
 		}
+		if rk.AltGr {
+			e.vkbd.KeyUp(uinput.KeyRightalt)
+		}
 	}
 }
 
 // performExpansion handles the full expansion sequence: backspace the
 // trigger, type/paste the replacement, and position the cursor.
-// extraBackspaces is 1 in space mode (to delete the trailing space) and 0 in immediate mode.
-func (e *Expander) performExpansion(m Match, extraBackspaces int) {
-	replacement := e.resolveReplacement(m)
+// terminator is the key typed after the trigger that fired it (a trigger
+// key in space mode, a separator for right_word, empty otherwise). It is
+// deleted along with the trigger and typed again after the replacement so
+// the user's separator is preserved.
+func (e *Expander) performExpansion(c candidate, terminator string) {
+	replacement := e.resolveReplacement(c)
+	undo := &lastExpansion{match: c.match, typed: c.typed, terminator: terminator, buf: e.buf, bufBoundary: e.bufBoundary}
+
+	// What follows the replacement starts on a boundary only if the
+	// replacement ends with a separator.
+	last, _ := utf8.DecodeLastRuneInString(replacement + terminator)
+	e.bufBoundary = replacement+terminator == "" || e.config.isWordSeparator(last)
 
 	// Handle $|$ cursor marker
 	cursorOffset := 0
@@ -136,21 +171,12 @@ func (e *Expander) performExpansion(m Match, extraBackspaces int) {
 		replacement = replacement[:idx] + after
 	}
 
-	e.sendBackspaces(utf8.RuneCountInString(m.Trigger) + extraBackspaces)
-
-	if canTypeDirectly(replacement) {
-		dbg("typing directly (%d chars)", utf8.RuneCountInString(replacement))
-		e.typeText(replacement)
-	} else if hasWtype && !wtypeBroken {
-		dbg("using wtype (%d chars, has unmappable runes)", utf8.RuneCountInString(replacement))
-		if err := e.wtypeText(replacement); err != nil {
-			dbg("wtype broken: %v — disabling, falling back to clipboard", err)
-			wtypeBroken = true
-			e.clipboardPaste(replacement)
-		}
-	} else {
-		dbg("clipboard paste (%d chars, unmappable runes)", utf8.RuneCountInString(replacement))
-		e.clipboardPaste(replacement)
+	// The terminator goes out with the replacement so that it cannot land
+	// ahead of a clipboard paste still in flight.
+	e.sendBackspaces(utf8.RuneCountInString(c.typed) + utf8.RuneCountInString(terminator))
+	e.emit(replacement + terminator)
+	if cursorOffset > 0 {
+		cursorOffset += utf8.RuneCountInString(terminator)
 	}
 
 	// Move cursor back if $|$ was present
@@ -159,16 +185,82 @@ func (e *Expander) performExpansion(m Match, extraBackspaces int) {
 			e.vkbd.KeyPress(uinput.KeyLeft)
 		}
 	}
+
+	// Only an expansion with text before the cursor can be undone: the
+	// undoing Backspace must delete part of it.
+	undo.length = utf8.RuneCountInString(replacement + terminator)
+	undo.cursorOffset = cursorOffset
+	if e.config.undoBackspace && undo.length > cursorOffset {
+		e.last = undo
+	}
 }
 
-// HandleEvent processes a single key event: tracks shift state, manages
-// the buffer, and fires expansions. Returns true if an expansion was
-// performed (caller should drain the event channel).
+// undoExpansion reverts the last expansion after Backspace was pressed
+// right after it. The Backspace already deleted one character; the rest
+// of the replacement is deleted and the trigger typed again, followed by
+// its terminator. The buffer then starts after the terminator, so the
+// trigger cannot fire again without being retyped.
+func (e *Expander) undoExpansion(last *lastExpansion) {
+	dbg("undo: %q → retyping trigger", last.typed)
+	for i := 0; i < last.cursorOffset; i++ {
+		e.vkbd.KeyPress(uinput.KeyRight)
+	}
+	e.sendBackspaces(last.length - 1)
+	e.emit(last.typed + last.terminator)
+	if last.terminator == "" {
+		e.buf, e.bufBoundary = last.buf, last.bufBoundary
+	} else {
+		r, _ := utf8.DecodeLastRuneInString(last.terminator)
+		e.buf, e.bufBoundary = "", e.config.isWordSeparator(r)
+	}
+	if e.config.undoSuppress {
+		e.suppressed = last.match
+	}
+}
+
+// emit types text into the focused application: directly via the virtual
+// keyboard when the keymap can produce every rune, else via wtype, with
+// clipboard paste as the last resort.
+func (e *Expander) emit(text string) {
+	if e.config.Keymap.CanType(text) {
+		dbg("typing directly (%d chars)", utf8.RuneCountInString(text))
+		e.typeText(text)
+	} else if hasWtype && !wtypeBroken {
+		dbg("using wtype (%d chars, has unmappable runes)", utf8.RuneCountInString(text))
+		if err := e.wtypeText(text); err != nil {
+			dbg("wtype broken: %v — disabling, falling back to clipboard", err)
+			wtypeBroken = true
+			e.clipboardPaste(text)
+		}
+	} else {
+		dbg("clipboard paste (%d chars, unmappable runes)", utf8.RuneCountInString(text))
+		e.clipboardPaste(text)
+	}
+}
+
+// HandleEvent processes a single key event: tracks modifier and lock
+// state, manages the buffer, and fires expansions. Returns true if an
+// expansion was performed (caller should drain the event channel).
 func (e *Expander) HandleEvent(ev KeyEvent) bool {
-	// Track shift state
-	if ev.Code == evdev.KEY_LEFTSHIFT || ev.Code == evdev.KEY_RIGHTSHIFT {
+	// Lock LEDs are the authoritative lock state
+	if ev.Type == evdev.EV_LED {
+		switch ev.Code {
+		case evdev.LED_CAPSL:
+			e.capsLock = ev.Value != 0
+		case evdev.LED_NUML:
+			e.numLock = ev.Value != 0
+		}
+		return false
+	}
+
+	// Track modifier state
+	switch {
+	case ev.Code == evdev.KEY_LEFTSHIFT || ev.Code == evdev.KEY_RIGHTSHIFT:
 		e.shift = ev.Value > 0
 		return false
+	case ev.Code == evdev.KEY_RIGHTALT && e.config.Keymap.HasAltGr():
+		e.altGr = ev.Value > 0
+		return false
 	}
 
 	// Only process key-down events
@@ -176,14 +268,62 @@ func (e *Expander) HandleEvent(ev KeyEvent) bool {
 		return false
 	}
 
+	// Only the key right after an expansion can undo it.
+	last := e.last
+	e.last = nil
+
+	// Toggle locks on press; the LED event that follows confirms the state
+	switch ev.Code {
+	case evdev.KEY_CAPSLOCK:
+		e.capsLock = !e.capsLock
+		return false
+	case evdev.KEY_NUMLOCK:
+		e.numLock = !e.numLock
+		return false
+	}
+
+	// Tab and Enter fire "space" mode matches when listed in
+	// trigger_keys, and reset the buffer either way.
+	if term, ok := e.config.triggerKeyCodes[ev.Code]; ok && e.dead == 0 {
+		dbg("%q pressed, buffer=%q, checking matches", term, e.buf)
+		if c, ok := e.find(e.buf, TriggerModeSpace, false); ok {
+			dbg("match: %q → expanding", c.typed)
+			e.performExpansion(c, term)
+			e.resetBuffer()
+			return true
+		}
+	}
+
+	// Tab and Enter also end a word: right_word immediate matches fire on
+	// them before the buffer is reset.
+	if sep, ok := separatorKeys[ev.Code]; ok && e.dead == 0 && e.config.hasImmediate {
+		if r, _ := utf8.DecodeRuneInString(sep); e.config.isWordSeparator(r) {
+			if c, ok := e.find(e.buf, TriggerModeImmediate, true); ok {
+				dbg("match: %q + separator %q → expanding", c.typed, sep)
+				e.performExpansion(c, sep)
+				e.resetBuffer()
+				return true
+			}
+		}
+	}
+
 	// Buffer reset keys
 	if BufferResetKeys[ev.Code] {
-		e.buf = ""
+		e.resetBuffer()
+		e.dead = 0
 		return false
 	}
 
-	// Backspace: remove last rune from buffer
+	// Backspace: cancel a pending dead key, else remove last rune from buffer
 	if ev.Code == evdev.KEY_BACKSPACE {
+		if last != nil {
+			e.undoExpansion(last)
+			return true
+		}
+		if e.dead != 0 {
+			e.dead = 0
+			return false
+		}
 		if len(e.buf) > 0 {
 			_, size := utf8.DecodeLastRuneInString(e.buf)
 			e.buf = e.buf[:len(e.buf)-size]
@@ -191,60 +331,284 @@ func (e *Expander) HandleEvent(ev KeyEvent) bool {
 		return false
 	}
 
-	// In "space" mode: check matches on space, then clear buffer
-	if e.config.TriggerMode != "immediate" && ev.Code == evdev.KEY_SPACE {
-		dbg("space pressed, buffer=%q, checking matches", e.buf)
-		for _, m := range e.config.Matches {
-			if !strings.HasSuffix(e.buf, m.Trigger) {
-				continue
-			}
-			dbg("match: trigger=%q → expanding", m.Trigger)
-			e.performExpansion(m, 1) // +1 for the space
-			e.buf = ""
-			return true
-		}
-		e.buf = ""
+	// Keypad digits navigate while NumLock is off
+	if _, ok := KeypadNumLockMap[ev.Code]; ok && !e.numLock {
+		e.resetBuffer()
 		return false
 	}
 
 	// Map keycode to character
-	kc, ok := KeyCharMap[ev.Code]
+	ch, ok := e.decode(ev.Code)
 	if !ok {
 		return false
 	}
+	if ch = e.compose(ch); ch == "" {
+		return false
+	}
 
-	ch := kc.Normal
-	if e.shift {
-		ch = kc.Shifted
+	// Trigger keys (space by default) fire "space" mode matches.
+	// Otherwise they are typed like any other character so "immediate"
+	// triggers may contain them.
+	if e.config.isTriggerChar(ch) {
+		dbg("%q pressed, buffer=%q, checking matches", ch, e.buf)
+		if c, ok := e.find(e.buf, TriggerModeSpace, false); ok {
+			dbg("match: %q → expanding", c.typed)
+			e.performExpansion(c, ch)
+			e.buf = "" // performExpansion set bufBoundary
+			return true
+		}
 	}
 
 	e.buf += ch
-	if len(e.buf) > e.maxLen {
-		e.buf = e.buf[len(e.buf)-e.maxLen:]
-	}
+	e.trimBuffer()
 
-	// In "immediate" mode: check matches after every keystroke
-	if e.config.TriggerMode == "immediate" {
+	// "immediate" mode matches are checked after every keystroke. Those
+	// with right_word wait for the separator typed after the trigger,
+	// which is deleted with the trigger and typed again after the
+	// replacement.
+	if e.config.hasImmediate {
 		dbg("key '%s', buffer=%q, checking matches", ch, e.buf)
-		for _, m := range e.config.Matches {
-			if !strings.HasSuffix(e.buf, m.Trigger) {
-				continue
-			}
-			dbg("match: trigger=%q → expanding", m.Trigger)
-			e.performExpansion(m, 0)
+		if c, ok := e.find(e.buf, TriggerModeImmediate, false); ok {
+			dbg("match: %q → expanding", c.typed)
+			e.performExpansion(c, "")
 			e.buf = ""
 			return true
 		}
+		if r, _ := utf8.DecodeRuneInString(ch); e.config.isWordSeparator(r) {
+			before := e.buf[:len(e.buf)-len(ch)]
+			if c, ok := e.find(before, TriggerModeImmediate, true); ok {
+				dbg("match: %q + separator %q → expanding", c.typed, ch)
+				e.buf = before // the state undo restores
+				e.performExpansion(c, ch)
+				e.buf = ""
+				return true
+			}
+		}
 	}
 	return false
 }
 
+// candidate is a match found at the end of the buffer.
+type candidate struct {
+	match    *Match
+	typed    string            // buffer text that matched
+	captures map[string]string // named regex groups
+}
+
+// find returns the match to expand at the end of buf among those in the
+// given trigger mode: the longest trigger first, then regex matches in
+// config order. In immediate mode rightWord selects the right_word matches
+// (checked once a separator has been typed) or the rest; space mode always
+// ends on a separator.
+func (e *Expander) find(buf, mode string, rightWord bool) (candidate, bool) {
+	accept := func(i int) bool {
+		m := &e.config.Matches[i]
+		start := len(buf) - len(typedSuffix(buf, m.Trigger))
+		return m != e.suppressed && m.hasMode(mode, rightWord) && (!m.LeftWord || e.boundaryAt(buf, start))
+	}
+	if i, ok := e.index.lookup(buf, accept); ok {
+		m := &e.config.Matches[i]
+		return candidate{match: m, typed: typedSuffix(buf, m.Trigger)}, true
+	}
+
+	for _, i := range e.config.regexMatches {
+		m := &e.config.Matches[i]
+		if m == e.suppressed || !m.hasMode(mode, rightWord) {
+			continue
+		}
+		loc := m.Regex.FindStringSubmatchIndex(buf)
+		if loc == nil || m.LeftWord && !e.boundaryAt(buf, loc[0]) {
+			continue
+		}
+		captures := make(map[string]string)
+		for g, name := range m.Regex.SubexpNames() {
+			if name != "" && loc[2*g] >= 0 {
+				captures[name] = buf[loc[2*g]:loc[2*g+1]]
+			}
+		}
+		return candidate{match: m, typed: buf[loc[0]:], captures: captures}, true
+	}
+
+	if m := e.config.calcMatch; m != nil && m != e.suppressed && m.hasMode(mode, rightWord) {
+		return e.findCalc(buf, m)
+	}
+	return candidate{}, false
+}
+
+// findCalc returns the =expr= buf ends with as a candidate for the
+// calculator match m, with the result as a capture. The opening = must
+// start a word so code such as x=y+1= is left alone, and the expression
+// must contain a digit.
+func (e *Expander) findCalc(buf string, m *Match) (candidate, bool) {
+	if !strings.HasSuffix(buf, "=") {
+		return candidate{}, false
+	}
+	body := buf[:len(buf)-1]
+	open := strings.LastIndexByte(body, '=')
+	if open < 0 || m.LeftWord && !e.boundaryAt(buf, open) {
+		return candidate{}, false
+	}
+	expr := body[open+1:]
+	if !strings.ContainsAny(expr, "0123456789") {
+		return candidate{}, false
+	}
+	v, err := evalCalc(expr)
+	if err != nil {
+		dbg("calculator: %q: %v", expr, err)
+		return candidate{}, false
+	}
+	captures := map[string]string{calcResult: formatCalc(v, e.config.calcPrecision)}
+	return candidate{match: m, typed: buf[open:], captures: captures}, true
+}
+
+// boundaryAt reports whether position start of buf is a word boundary:
+// preceded by a separator, or the start of a buffer that starts on one.
+func (e *Expander) boundaryAt(buf string, start int) bool {
+	if start <= 0 {
+		return e.bufBoundary
+	}
+	r, _ := utf8.DecodeLastRuneInString(buf[:start])
+	return e.config.isWordSeparator(r)
+}
+
+// typedSuffix returns the end of buf that matched trigger: as many runes
+// as the trigger has. For case-insensitive matches its bytes may differ
+// from the trigger's.
+func typedSuffix(buf, trigger string) string {
+	start := len(buf)
+	for n := utf8.RuneCountInString(trigger); n > 0 && start > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(buf[:start])
+		start -= size
+	}
+	return buf[start:]
+}
+
+// resetBuffer clears the buffer after the cursor moved or the line ended;
+// whatever is typed next starts on a word boundary. An undone match may
+// fire again.
+func (e *Expander) resetBuffer() {
+	e.buf = ""
+	e.bufBoundary = true
+	e.suppressed = nil
+}
+
+// trimBuffer drops the oldest runes once the buffer outgrows the longest
+// trigger. One extra rune is kept so left_word can inspect the character
+// before a trigger of maximal length.
+func (e *Expander) trimBuffer() {
+	keep := e.maxLen + utf8.UTFMax
+	if len(e.buf) <= keep {
+		return
+	}
+	cut := len(e.buf) - keep
+	for cut < len(e.buf) && !utf8.RuneStart(e.buf[cut]) {
+		cut++
+	}
+	e.buf = e.buf[cut:]
+	e.bufBoundary = false
+}
+
+// decode returns the character a key press produces under the current
+// modifier and lock state. Keys without a third level fall back to their
+// first two levels while AltGr is held, as in XKB.
+func (e *Expander) decode(code evdev.EvCode) (string, bool) {
+	if ch, ok := KeypadNumLockMap[code]; ok {
+		return ch, true
+	}
+	if ch, ok := KeypadCharMap[code]; ok {
+		return ch, true
+	}
+	if e.altGr {
+		if kc, ok := e.config.Keymap.AltGr[code]; ok {
+			return kc.Char(e.shift, e.capsLock), true
+		}
+	}
+	kc, ok := e.config.Keymap.Chars[code]
+	if !ok {
+		return "", false
+	}
+	return kc.Char(e.shift, e.capsLock), true
+}
+
+// compose runs the dead-key state machine on a decoded character and
+// returns the text it types: nothing while a dead key is pending, the
+// composed rune when the next character combines with it, or the spacing
+// accent followed by the character otherwise.
+func (e *Expander) compose(ch string) string {
+	if isDeadKey(ch) {
+		dead, _ := utf8.DecodeRuneInString(ch)
+		prev := e.dead
+		switch {
+		case prev == 0:
+			e.dead = dead
+			return ""
+		case prev == dead:
+			// Pressing a dead key twice types its accent
+			e.dead = 0
+		default:
+			e.dead = dead
+		}
+		return deadKeyText(prev)
+	}
+
+	if e.dead == 0 {
+		return ch
+	}
+	dead := e.dead
+	e.dead = 0
+	if ch == " " {
+		return deadKeyText(dead)
+	}
+	return composeDeadKey(dead, ch)
+}
+
+// maxMatchDepth limits how deeply match variables may nest.
+const maxMatchDepth = 8
+
 // resolveReplacement computes the final replacement text for a match,
-// resolving date variables and {{ref}} placeholders.
-func (e *Expander) resolveReplacement(m Match) string {
-	now := time.Now()
-	vars := ResolveVars(m.GlobalVars, m.Vars, now)
-	return expandRefs(m.Replace, vars)
+// resolving variables, regex captures and {{ref}} placeholders, then
+// propagating the typed trigger's case.
+func (e *Expander) resolveReplacement(c candidate) string {
+	m := c.match
+	replacement, _ := e.expandMatch(m, c.captures, time.Now(), nil)
+	if m.PropagateCase {
+		replacement = propagateCase(replacement, c.typed, m.UppercaseStyle)
+	}
+	return replacement
+}
+
+// expandMatch resolves m's variables and replacement. stack holds the
+// matches whose match variables led here, to detect cycles.
+func (e *Expander) expandMatch(m *Match, captures map[string]string, now time.Time, stack []*Match) (string, error) {
+	if slices.Contains(stack, m) {
+		return "", fmt.Errorf("match variable cycle through trigger %q", m.Trigger)
+	}
+	if len(stack) >= maxMatchDepth {
+		return "", fmt.Errorf("match variables nested deeper than %d", maxMatchDepth)
+	}
+	stack = append(stack, m)
+
+	env := varEnv{
+		now:           now,
+		choose:        e.rotation.chooser(m.fingerprint),
+		locale:        e.config.locale,
+		configDir:     e.config.dir,
+		calcPrecision: e.config.calcPrecision,
+		plugins:       e.plugins,
+		match: func(trigger string) (string, error) {
+			nested, ok := e.config.matchByTrigger(trigger)
+			if !ok {
+				return "", fmt.Errorf("no match with trigger %q", trigger)
+			}
+			return e.expandMatch(nested, nil, now, stack)
+		},
+	}
+	template := m.Replace
+	if len(m.Replaces) > 0 {
+		template = m.Replaces[env.choose("", len(m.Replaces), m.Choice)]
+	}
+	vars := ResolveVars(m.GlobalVars, m.Vars, template, maps.Clone(captures), env)
+	return expandRefs(template, vars), nil
 }
 
 // sendBackspaces sends n backspace key presses via the virtual keyboard.
@@ -269,7 +633,7 @@ func (e *Expander) wtypeText(text string) error {
 // paste, then restores the previous clipboard asynchronously.
 func (e *Expander) clipboardPaste(text string) {
 	// Save current clipboard
-	oldClip, _ := exec.Command("wl-paste", "-n").Output()
+	oldClip, _ := wlPaste(false, defaultClipboardTimeout)
 
 	// Copy replacement text (.Run() blocks until complete — no extra sleep needed)
 	exec.Command("wl-copy", "--", text).Run()
@@ -286,7 +650,7 @@ func (e *Expander) clipboardPaste(text string) {
 	if len(oldClip) > 0 {
 		go func() {
 			time.Sleep(200 * time.Millisecond)
-			exec.Command("wl-copy", "--", string(oldClip)).Run()
+			exec.Command("wl-copy", "--", oldClip).Run()
 		}()
 	}
 }
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bendahl/uinput"
+	evdev "github.com/holoplot/go-evdev"
+	"golang.org/x/text/unicode/norm"
+)
+
+// fakeKeyboard is a uinput.Keyboard that types into an in-memory text
+// field, as the focused application would. Dead keys combine with the
+// next character.
+type fakeKeyboard struct {
+	keymap *Keymap
+	text   []rune
+	cursor int
+	shift  bool
+	altGr  bool
+	dead   string
+}
+
+func (k *fakeKeyboard) KeyDown(key int) error {
+	switch key {
+	case uinput.KeyLeftshift:
+		k.shift = true
+	case uinput.KeyRightalt:
+		k.altGr = true
+	}
+	return nil
+}
+
+func (k *fakeKeyboard) KeyUp(key int) error {
+	switch key {
+	case uinput.KeyLeftshift:
+		k.shift = false
+	case uinput.KeyRightalt:
+		k.altGr = false
+	}
+	return nil
+}
+
+func (k *fakeKeyboard) KeyPress(key int) error {
+	switch key {
+	case uinput.KeyBackspace:
+		if k.dead != "" {
+			k.dead = ""
+		} else if k.cursor > 0 {
+			k.text = slices.Delete(k.text, k.cursor-1, k.cursor)
+			k.cursor--
+		}
+	case uinput.KeyLeft:
+		k.cursor = max(k.cursor-1, 0)
+	case uinput.KeyRight:
+		k.cursor = min(k.cursor+1, len(k.text))
+	case uinput.KeyEnter, uinput.KeyKpenter:
+		k.insert("\n")
+	case uinput.KeyTab:
+		k.insert("\t")
+	default:
+		levels := k.keymap.Chars
+		if k.altGr {
+			levels = k.keymap.AltGr
+		}
+		if kc, ok := levels[evdev.EvCode(key)]; ok {
+			if ch := kc.Char(k.shift, false); isDeadKey(ch) {
+				k.dead = ch
+			} else {
+				k.insert(norm.NFC.String(ch + k.dead))
+				k.dead = ""
+			}
+		}
+	}
+	return nil
+}
+
+func (k *fakeKeyboard) insert(s string) {
+	r := []rune(s)
+	k.text = slices.Insert(k.text, k.cursor, r...)
+	k.cursor += len(r)
+}
+
+// String returns the field's text with | at the cursor.
+func (k *fakeKeyboard) String() string {
+	return string(k.text[:k.cursor]) + "|" + string(k.text[k.cursor:])
+}
+
+func (k *fakeKeyboard) FetchSyspath() (string, error) { return "", nil }
+func (k *fakeKeyboard) Close() error                  { return nil }
+
+// typist presses physical keys: each key reaches the text field, then
+// the expander, as with a real keyboard texpand does not grab. Test
+// replacements must be typeable with the keymap, or the expander would
+// fall back to wtype or the clipboard.
+type typist struct {
+	e  *Expander
+	kb *fakeKeyboard
+}
+
+func newTypist(t *testing.T, files map[string]string) *typist {
+	t.Helper()
+	cfg := loadTestConfig(t, files)
+	kb := &fakeKeyboard{keymap: cfg.Keymap}
+	return &typist{e: NewExpander(cfg, kb), kb: kb}
+}
+
+// press presses and releases a key, with Shift held if shift is set.
+func (ty *typist) press(code evdev.EvCode, shift bool) {
+	if shift {
+		ty.e.HandleEvent(KeyEvent{Type: evdev.EV_KEY, Code: evdev.KEY_LEFTSHIFT, Value: 1})
+		ty.kb.KeyDown(uinput.KeyLeftshift)
+	}
+	ty.kb.KeyPress(int(code))
+	ty.e.HandleEvent(KeyEvent{Type: evdev.EV_KEY, Code: code, Value: 1})
+	ty.e.HandleEvent(KeyEvent{Type: evdev.EV_KEY, Code: code, Value: 0})
+	if shift {
+		ty.kb.KeyUp(uinput.KeyLeftshift)
+		ty.e.HandleEvent(KeyEvent{Type: evdev.EV_KEY, Code: evdev.KEY_LEFTSHIFT, Value: 0})
+	}
+}
+
+// typeText types text key by key.
+func (ty *typist) typeText(text string) {
+	for _, r := range text {
+		rk := ty.kb.keymap.Reverse[r]
+		ty.press(evdev.EvCode(rk.Code), rk.Shift)
+	}
+}
+
+func TestUndoExpansion(t *testing.T) {
+	const matches = `matches:
+  - trigger: "brb"
+    replace: "be right back"
+  - trigger: "'sig"
+    replace: "Hi $|$, bye"
+  - trigger: ":ok"
+    replace: "okay"
+    trigger_mode: immediate
+  - trigger: "ty"
+    replace: "thank you"
+    trigger_mode: immediate
+    right_word: true
+`
+	tests := []struct {
+		name     string
+		config   string
+		typed    string
+		expanded string
+		undone   string
+		after    string // typed after the undo
+		want     string
+	}{
+		{"space", "", "brb ", "be right back |", "brb |", "x ", "brb x |"},
+		{"space again", "", "brb ", "be right back |", "brb |", " ", "brb  |"},
+		{"period", "trigger_keys: [space, punctuation]\n", "brb.", "be right back.|", "brb.|", " ", "brb. |"},
+		{"cursor marker", "", "a 'sig ", "a Hi |, bye ", "a 'sig |", "x", "a 'sig x|"},
+		{"immediate", "", ":ok", "okay|", ":ok|", " ", ":ok |"},
+		{"right_word", "", "ty,", "thank you,|", "ty,|", " ", "ty, |"},
+		{"retyped trigger fires", "", "brb ", "be right back |", "brb |", "brb ", "brb be right back |"},
+		{"suppressed", "undo_suppress: true\n", "brb ", "be right back |", "brb |", "brb ", "brb brb |"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ty := newTypist(t, map[string]string{"config.yml": tt.config, "match/m.yml": matches})
+			ty.typeText(tt.typed)
+			if got := ty.kb.String(); got != tt.expanded {
+				t.Fatalf("after %q: got %q, want %q", tt.typed, got, tt.expanded)
+			}
+			ty.press(evdev.KEY_BACKSPACE, false)
+			if got := ty.kb.String(); got != tt.undone {
+				t.Fatalf("after undo: got %q, want %q", got, tt.undone)
+			}
+			ty.typeText(tt.after)
+			if got := ty.kb.String(); got != tt.want {
+				t.Errorf("after %q: got %q, want %q", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUndoOnlyRightAfter(t *testing.T) {
+	ty := newTypist(t, map[string]string{"match/m.yml": `matches:
+  - trigger: "brb"
+    replace: "be right back"
+`})
+	ty.typeText("brb x")
+	ty.press(evdev.KEY_BACKSPACE, false)
+	if got, want := ty.kb.String(), "be right back |"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	ty = newTypist(t, map[string]string{
+		"config.yml":  "undo_backspace: false\n",
+		"match/m.yml": "matches:\n  - trigger: \"brb\"\n    replace: \"be right back\"\n",
+	})
+	ty.typeText("brb ")
+	ty.press(evdev.KEY_BACKSPACE, false)
+	if got, want := ty.kb.String(), "be right back|"; got != want {
+		t.Errorf("undo_backspace: false: got %q, want %q", got, want)
+	}
+}
+
+func TestDeadKeys(t *testing.T) {
+	t.Setenv("XKB_CONFIG_ROOT", "testdata/xkb")
+
+	// In the test layout ` and ' are dead grave and dead acute.
+	type press struct {
+		code  evdev.EvCode
+		shift bool
+	}
+	grave := press{evdev.KEY_GRAVE, false}
+	acute := press{evdev.KEY_APOSTROPHE, false}
+	diaeresis := press{evdev.KEY_APOSTROPHE, true}
+	key := func(code evdev.EvCode) press { return press{code, false} }
+	shifted := func(code evdev.EvCode) press { return press{code, true} }
+
+	tests := []struct {
+		name    string
+		presses []press
+		want    string
+	}{
+		{"dead key and letter", []press{grave, key(evdev.KEY_A)}, "à"},
+		{"dead key and capital", []press{acute, shifted(evdev.KEY_A)}, "Á"},
+		{"shifted dead key", []press{diaeresis, key(evdev.KEY_A)}, "ä"},
+		{"dead key twice", []press{grave, grave}, "`"},
+		{"dead key twice, then a letter", []press{grave, grave, key(evdev.KEY_A)}, "`a"},
+		{"dead key and space", []press{acute, key(evdev.KEY_SPACE)}, "´"},
+		{"letter that does not compose", []press{grave, key(evdev.KEY_S)}, "`s"},
+		{"another dead key", []press{grave, acute, key(evdev.KEY_A)}, "`á"},
+		{"backspace cancels", []press{key(evdev.KEY_A), grave, key(evdev.KEY_BACKSPACE), key(evdev.KEY_S)}, "as"},
+		{"reset key cancels", []press{grave, key(evdev.KEY_ESC), key(evdev.KEY_S)}, "s"},
+		{"pending dead key types nothing", []press{key(evdev.KEY_S), grave}, "s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ty := newTypist(t, map[string]string{"config.yml": "keyboard_layout: test\n"})
+			for _, p := range tt.presses {
+				ty.press(p.code, p.shift)
+			}
+			if ty.e.buf != tt.want {
+				t.Errorf("buffer = %q, want %q", ty.e.buf, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeadKeyTrigger(t *testing.T) {
+	t.Setenv("XKB_CONFIG_ROOT", "testdata/xkb")
+	ty := newTypist(t, map[string]string{
+		"config.yml":  "keyboard_layout: test\n",
+		"match/m.yml": "matches:\n  - trigger: \"sà\"\n    replace: \"as\"\n",
+	})
+	ty.press(evdev.KEY_S, false)
+	ty.press(evdev.KEY_GRAVE, false)
+	ty.press(evdev.KEY_A, false)
+	ty.press(evdev.KEY_SPACE, false)
+	if got, want := ty.kb.String(), "as |"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	t.Setenv("XKB_CONFIG_ROOT", "testdata/xkb")
+
+	// test(groups) has levels 3-4 on A (æ Æ), S (ß §) and D (€), none on
+	// Q; latin has no AltGr key at all.
+	tests := []struct {
+		layout                 string
+		altGr, shift, capsLock bool
+		code                   evdev.EvCode
+		want                   string
+	}{
+		{"test(groups)", false, false, false, evdev.KEY_A, "a"},
+		{"test(groups)", false, false, true, evdev.KEY_A, "A"},
+		{"test(groups)", false, true, true, evdev.KEY_A, "a"},
+		{"test(groups)", false, false, true, evdev.KEY_1, "1"},
+		{"test(groups)", true, false, false, evdev.KEY_A, "æ"},
+		{"test(groups)", true, true, false, evdev.KEY_A, "Æ"},
+		{"test(groups)", true, false, true, evdev.KEY_A, "Æ"},
+		{"test(groups)", true, false, false, evdev.KEY_S, "ß"},
+		{"test(groups)", true, false, true, evdev.KEY_S, "ß"},
+		{"test(groups)", true, true, false, evdev.KEY_D, "€"},
+		// Keys without a third level fall back to the first two.
+		{"test(groups)", true, false, false, evdev.KEY_Q, "q"},
+		{"test(groups)", true, true, false, evdev.KEY_Q, "Q"},
+		{"test(groups)", true, false, true, evdev.KEY_Q, "Q"},
+		// Without ralt_switch Right Alt is not AltGr.
+		{"latin", true, false, false, evdev.KEY_A, "a"},
+		{"latin", true, true, false, evdev.KEY_1, "!"},
+	}
+	for _, tt := range tests {
+		ty := newTypist(t, map[string]string{"config.yml": "keyboard_layout: " + tt.layout + "\n"})
+		if tt.capsLock {
+			ty.e.HandleEvent(KeyEvent{Type: evdev.EV_LED, Code: evdev.LED_CAPSL, Value: 1})
+		}
+		if tt.altGr {
+			ty.e.HandleEvent(KeyEvent{Type: evdev.EV_KEY, Code: evdev.KEY_RIGHTALT, Value: 1})
+		}
+		ty.press(tt.code, tt.shift)
+		if ty.e.buf != tt.want {
+			t.Errorf("%s altGr=%v shift=%v capsLock=%v key %d: got %q, want %q",
+				tt.layout, tt.altGr, tt.shift, tt.capsLock, tt.code, ty.e.buf, tt.want)
+		}
+	}
+}
+
+func TestCapsLockToggle(t *testing.T) {
+	ty := newTypist(t, nil)
+	ty.press(evdev.KEY_CAPSLOCK, false)
+	ty.press(evdev.KEY_A, false)
+	ty.press(evdev.KEY_1, false)
+	// The LED is authoritative: it turns CapsLock off.
+	ty.e.HandleEvent(KeyEvent{Type: evdev.EV_LED, Code: evdev.LED_CAPSL, Value: 0})
+	ty.press(evdev.KEY_A, false)
+	if got, want := ty.e.buf, "A1a"; got != want {
+		t.Errorf("buffer = %q, want %q", got, want)
+	}
+}
+
+func TestMatchVarCycleAtExpansion(t *testing.T) {
+	ty := newTypist(t, map[string]string{"match/m.yml": `matches:
+  - trigger: "'a"
+    replace: "a{{b}}"
+    vars:
+      - {name: b, type: match, params: {trigger: "'b"}}
+  - trigger: "'b"
+    replace: "b"
+`})
+	// Cycles are rejected at load time; make one behind its back to check
+	// that expansion still stops.
+	b, _ := ty.e.config.matchByTrigger("'b")
+	b.Replace = "b{{a}}"
+	b.Vars = []VarDef{{Name: "a", Type: "match", Params: VarParams{Trigger: "'a"}}}
+
+	done := make(chan struct{})
+	go func() {
+		ty.typeText("'a ")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expansion did not stop")
+	}
+	if got, want := ty.kb.String(), "ab |"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMatchVarChain(t *testing.T) {
+	ty := newTypist(t, map[string]string{"match/m.yml": matchVarChain(maxMatchDepth)})
+	ty.typeText("'m0 ")
+	if got, want := ty.kb.String(), "end |"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	const matches = `matches:
+  - trigger: "'rr"
+    replaces: ["a", "b", "c"]
+    choice: round_robin
+  - trigger: "'v"
+    replace: "{{pick}}"
+    vars:
+      - {name: pick, type: random, params: {choices: ["x", "y"], choice: round_robin}}
+  - trigger: "'one"
+    replaces: ["only"]
+`
+	expand := func(ty *typist, trigger string) string {
+		ty.kb.text, ty.kb.cursor = nil, 0
+		ty.typeText(trigger + " ")
+		return ty.kb.String()
+	}
+
+	ty := newTypist(t, map[string]string{"match/m.yml": matches})
+	for _, want := range []string{"a |", "b |"} {
+		if got := expand(ty, "'rr"); got != want {
+			t.Errorf("'rr: got %q, want %q", got, want)
+		}
+	}
+	if got, want := expand(ty, "'v"), "x |"; got != want {
+		t.Errorf("'v: got %q, want %q", got, want)
+	}
+
+	// Reloading the same config keeps rotating.
+	ty.e.Reload(loadTestConfig(t, map[string]string{"match/m.yml": matches}))
+	for _, tc := range []struct{ trigger, want string }{
+		{"'rr", "c |"}, {"'rr", "a |"}, {"'v", "y |"}, {"'v", "x |"},
+	} {
+		if got := expand(ty, tc.trigger); got != tc.want {
+			t.Errorf("after reload, %s: got %q, want %q", tc.trigger, got, tc.want)
+		}
+	}
+
+	// Changing the match starts its rotation over.
+	changed := strings.Replace(matches, `["a", "b", "c"]`, `["a", "b", "d"]`, 1)
+	ty.e.Reload(loadTestConfig(t, map[string]string{"match/m.yml": changed}))
+	if got, want := expand(ty, "'rr"), "a |"; got != want {
+		t.Errorf("after changing the match: got %q, want %q", got, want)
+	}
+
+	for range 5 {
+		if got, want := expand(ty, "'one"), "only |"; got != want {
+			t.Errorf("single replace: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestTriggerKeyCodes(t *testing.T) {
+	const matches = "matches:\n  - trigger: \"brb\"\n    replace: \"be right back\"\n"
+	tests := []struct {
+		name   string
+		config string
+		key    evdev.EvCode
+		want   string
+	}{
+		{"tab", "trigger_keys: [space, tab]\n", evdev.KEY_TAB, "be right back\t|"},
+		{"enter", "trigger_keys: [space, enter]\n", evdev.KEY_ENTER, "be right back\n|"},
+		{"keypad enter", "trigger_keys: [enter]\n", evdev.KEY_KPENTER, "be right back\n|"},
+		{"tab not listed", "", evdev.KEY_TAB, "brb\t|"},
+		{"enter not listed", "trigger_keys: [tab]\n", evdev.KEY_ENTER, "brb\n|"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ty := newTypist(t, map[string]string{"config.yml": tt.config, "match/m.yml": matches})
+			ty.typeText("brb")
+			ty.press(tt.key, false)
+			if got := ty.kb.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileTriggerMode(t *testing.T) {
+	ty := newTypist(t, map[string]string{"match/m.yml": `trigger_mode: immediate
+matches:
+  - trigger: "'i"
+    replace: "now"
+  - trigger: "'s"
+    replace: "later"
+    trigger_mode: space
+`})
+	ty.typeText("'i")
+	if got, want := ty.kb.String(), "now|"; got != want {
+		t.Errorf("file mode: got %q, want %q", got, want)
+	}
+	ty.typeText(" 's")
+	if got, want := ty.kb.String(), "now 's|"; got != want {
+		t.Errorf("before space: got %q, want %q", got, want)
+	}
+	ty.typeText(" ")
+	if got, want := ty.kb.String(), "now later |"; got != want {
+		t.Errorf("match mode: got %q, want %q", got, want)
+	}
+}
+
+func TestRightWordSeparators(t *testing.T) {
+	const matches = `matches:
+  - trigger: "ty"
+    replace: "thank you"
+    trigger_mode: immediate
+    right_word: true
+  - trigger: "btw"
+    replace: "by the way"
+    trigger_mode: immediate
+    word: true
+`
+	tests := []struct {
+		name  string
+		typed string
+		want  string
+	}{
+		{"space", "ty ", "thank you |"},
+		{"tab", "ty\t", "thank you\t|"},
+		{"enter", "ty\n", "thank you\n|"},
+		{"word, space", "a btw ", "a by the way |"},
+		{"word, tab", "a btw\t", "a by the way\t|"},
+		{"word, enter", "a btw\n", "a by the way\n|"},
+		{"inside a word", "tyk\n", "tyk\n|"},
+		{"word, left side", "abtw\t", "abtw\t|"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ty := newTypist(t, map[string]string{"match/m.yml": matches})
+			ty.typeText(tt.typed)
+			if got := ty.kb.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegexTrigger(t *testing.T) {
+	const matches = `matches:
+  - regex: "'t(?P<id>\\d+)"
+    replace: "PROJ-{{id}}"
+  - regex: ":(?P<name>[a-z]+):"
+    replace: "<{{name}}>"
+    trigger_mode: immediate
+  - regex: "'d(?P<a>\\d)(?P<b>\\d)?"
+    replace: "{{b}}{{a}}"
+`
+	digits := strings.Repeat("1", 30)
+	tests := []struct {
+		name   string
+		config string
+		typed  string
+		want   string
+	}{
+		{"captures", "", "see 't123 ", "see PROJ-123 |"},
+		{"immediate", "", "a :smile:", "a <smile>|"},
+		{"optional group", "", "'d4 'd42 ", "4 24 |"},
+		{"no match", "", "'tx ", "'tx |"},
+		{"within regex_window", "", "'t" + digits + " ", "PROJ-" + digits + " |"},
+		{"beyond regex_window", "regex_window: 3\n", "'t" + digits + " ", "'t" + digits + " |"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ty := newTypist(t, map[string]string{"config.yml": tt.config, "match/m.yml": matches})
+			ty.typeText(tt.typed)
+			if got := ty.kb.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,7 +6,9 @@ require (
 	github.com/bendahl/uinput v1.7.0
 	github.com/fsnotify/fsnotify v1.9.0
 	github.com/holoplot/go-evdev v0.0.0-20250804134636-ab1d56a1fe83
+	go.starlark.net v0.0.0-20260908191801-89a6a09411d5
+	golang.org/x/text v0.29.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.13.0 // indirect
+require golang.org/x/sys v0.42.0 // indirect
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultRandomStringLength and defaultRandomAlphabet are used by
+// random_string variables without length/alphabet params.
+const (
+	defaultRandomStringLength = 16
+	defaultRandomAlphabet     = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	maxRandomStringLength     = 4096
+)
+
+// validateUUIDVar checks a uuid variable's params at load time.
+func validateUUIDVar(p VarParams) error {
+	if p.Version != 0 && p.Version != 4 && p.Version != 7 {
+		return fmt.Errorf("unsupported uuid version %d (want 4 or 7)", p.Version)
+	}
+	return nil
+}
+
+// validateRandomStringVar checks a random_string variable's params at
+// load time.
+func validateRandomStringVar(p VarParams) error {
+	if p.Length < 0 || p.Length > maxRandomStringLength {
+		return fmt.Errorf("length must be between 1 and %d (or 0 for the default, %d), got %d", maxRandomStringLength, defaultRandomStringLength, p.Length)
+	}
+	if !utf8.ValidString(p.Alphabet) {
+		return fmt.Errorf("alphabet is not valid UTF-8")
+	}
+	return nil
+}
+
+// newUUID returns a random (version 4) or time-ordered (version 7) UUID
+// in its canonical form.
+func newUUID(version int, now time.Time) (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	if version == 7 {
+		// The first 48 bits are the Unix time in milliseconds.
+		var ms [8]byte
+		binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+		copy(u[:6], ms[2:])
+	} else {
+		version = 4
+	}
+	u[6] = u[6]&0x0f | byte(version)<<4
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+
+	h := hex.EncodeToString(u[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// resolveUUID returns a new UUID for a uuid variable.
+func resolveUUID(name string, p VarParams, now time.Time) string {
+	u, err := newUUID(p.Version, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: uuid var %q: %v\n", name, err)
+	}
+	return u
+}
+
+// resolveRandomString returns length runes drawn uniformly from alphabet
+// with a cryptographic random source, so the result can be used as a
+// password or token.
+func resolveRandomString(name string, p VarParams) string {
+	length := p.Length
+	if length == 0 {
+		length = defaultRandomStringLength
+	}
+	alphabet := []rune(p.Alphabet)
+	if len(alphabet) == 0 {
+		alphabet = []rune(defaultRandomAlphabet)
+	}
+
+	out := make([]rune, length)
+	n := big.NewInt(int64(len(alphabet)))
+	for i := range out {
+		j, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "texpand: WARNING: random_string var %q: %v\n", name, err)
+			return ""
+		}
+		out[i] = alphabet[j.Int64()]
+	}
+	return string(out)
+}
@@ -7,8 +7,10 @@ import (
 )
 
 // KeyEvent carries a key code and value (1=press, 0=release, 2=repeat)
-// from a keyboard monitoring goroutine.
+// from a keyboard monitoring goroutine. Type is EV_KEY for key events and
+// EV_LED for lock LED state (Value 1=on, 0=off).
 type KeyEvent struct {
+	Type  evdev.EvType
 	Code  evdev.EvCode
 	Value int32
 }
@@ -111,6 +113,26 @@ func RefreshKeyboardMonitors(monitors map[string]monitoredKeyboard, ch chan<- Ke
 	return changed, nil
 }
 
+// sendLEDState reports the device's current CapsLock and NumLock LED state
+// so lock tracking starts in sync with the keyboard.
+func sendLEDState(dev *evdev.InputDevice, ch chan<- KeyEvent) {
+	if len(dev.CapableEvents(evdev.EV_LED)) == 0 {
+		return
+	}
+	leds, err := dev.State(evdev.EV_LED)
+	if err != nil {
+		dbg("read LED state: %v", err)
+		return
+	}
+	for _, code := range []evdev.EvCode{evdev.LED_CAPSL, evdev.LED_NUML} {
+		var value int32
+		if leds[code] {
+			value = 1
+		}
+		ch <- KeyEvent{Type: evdev.EV_LED, Code: code, Value: value}
+	}
+}
+
 // MonitorKeyboard reads events from a single keyboard device and sends key
 // events on the channel. It exits when the device is closed or errors, and
 // reports the stopped device path so the main loop can rescan hotplugged
@@ -121,14 +143,15 @@ func MonitorKeyboard(dev *evdev.InputDevice, ch chan<- KeyEvent, done chan<- key
 	defer func() {
 		done <- keyboardMonitorExit{path: path, dev: dev}
 	}()
+	sendLEDState(dev, ch)
 	for {
 		ev, err := dev.ReadOne()
 		if err != nil {
 			dbg("keyboard monitor stopped: %s (%s): %v", name, path, err)
 			return
 		}
-		if ev.Type == evdev.EV_KEY {
-			ch <- KeyEvent{Code: ev.Code, Value: ev.Value}
+		if ev.Type == evdev.EV_KEY || ev.Type == evdev.EV_LED {
+			ch <- KeyEvent{Type: ev.Type, Code: ev.Code, Value: ev.Value}
 		}
 	}
 }
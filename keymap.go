@@ -1,15 +1,44 @@
 package main
 
-import evdev "github.com/holoplot/go-evdev"
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bendahl/uinput"
+	evdev "github.com/holoplot/go-evdev"
+)
 
 // KeyChar maps an evdev keycode to its normal and shifted characters.
+// The same pair describes a key's AltGr levels (third and fourth).
 type KeyChar struct {
 	Normal  string
 	Shifted string
 }
 
+// Char returns the character produced with the given Shift and CapsLock
+// state. CapsLock inverts Shift on alphabetic keys only, like XKB's
+// ALPHABETIC key types.
+func (kc KeyChar) Char(shift, capsLock bool) string {
+	if capsLock && kc.alphabetic() {
+		shift = !shift
+	}
+	if shift {
+		return kc.Shifted
+	}
+	return kc.Normal
+}
+
+// alphabetic reports whether the key types a lowercase letter and its
+// uppercase form.
+func (kc KeyChar) alphabetic() bool {
+	r, _ := utf8.DecodeRuneInString(kc.Normal)
+	return unicode.IsLower(r) && kc.Shifted == string(unicode.ToUpper(r))
+}
+
 // KeyCharMap maps evdev key codes to their character representations
-// for a US/International keyboard layout.
+// for a US/International keyboard layout. It is the fallback when no
+// keyboard_layout is configured or the XKB layout cannot be loaded.
 var KeyCharMap = map[evdev.EvCode]KeyChar{
 	evdev.KEY_A: {"a", "A"}, evdev.KEY_B: {"b", "B"},
 	evdev.KEY_C: {"c", "C"}, evdev.KEY_D: {"d", "D"},
@@ -45,6 +74,108 @@ var KeyCharMap = map[evdev.EvCode]KeyChar{
 	evdev.KEY_SPACE:      {" ", " "},
 }
 
+// KeypadNumLockMap maps keypad keys that type a digit only while NumLock is
+// on. With NumLock off they act as navigation keys.
+var KeypadNumLockMap = map[evdev.EvCode]string{
+	evdev.KEY_KP0: "0", evdev.KEY_KP1: "1", evdev.KEY_KP2: "2",
+	evdev.KEY_KP3: "3", evdev.KEY_KP4: "4", evdev.KEY_KP5: "5",
+	evdev.KEY_KP6: "6", evdev.KEY_KP7: "7", evdev.KEY_KP8: "8",
+	evdev.KEY_KP9: "9", evdev.KEY_KPDOT: ".",
+}
+
+// KeypadCharMap maps keypad operator keys, which type the same character
+// regardless of NumLock and layout.
+var KeypadCharMap = map[evdev.EvCode]string{
+	evdev.KEY_KPSLASH:    "/",
+	evdev.KEY_KPASTERISK: "*",
+	evdev.KEY_KPMINUS:    "-",
+	evdev.KEY_KPPLUS:     "+",
+}
+
+// ReverseKey maps a character to the uinput key code needed to type it,
+// plus whether Shift and AltGr must be held. Alphabetic keys need Shift
+// inverted while CapsLock is on.
+type ReverseKey struct {
+	Code       int
+	Shift      bool
+	AltGr      bool
+	Alphabetic bool
+}
+
+// Keymap pairs the decode tables used for incoming key events with the
+// reverse table used to type replacement text. AltGr holds the third and
+// fourth levels and is empty when the layout has no AltGr key.
+type Keymap struct {
+	Chars   map[evdev.EvCode]KeyChar
+	AltGr   map[evdev.EvCode]KeyChar
+	Reverse map[rune]ReverseKey
+}
+
+// usKeymap is the built-in US keymap built from KeyCharMap.
+var usKeymap = NewKeymap(KeyCharMap, nil)
+
+// NewKeymap builds a Keymap from the level 1–2 and level 3–4 decode tables.
+// When a character is reachable from several keys, the lowest level wins.
+func NewKeymap(chars, altGr map[evdev.EvCode]KeyChar) *Keymap {
+	reverse := make(map[rune]ReverseKey, len(chars)*2+len(altGr)*2)
+	addReverseLevel(reverse, chars, false, false, func(kc KeyChar) string { return kc.Normal })
+	addReverseLevel(reverse, chars, true, false, func(kc KeyChar) string { return kc.Shifted })
+	addReverseLevel(reverse, altGr, false, true, func(kc KeyChar) string { return kc.Normal })
+	addReverseLevel(reverse, altGr, true, true, func(kc KeyChar) string { return kc.Shifted })
+	// Special keys not in the decode table
+	reverse['\n'] = ReverseKey{Code: uinput.KeyEnter, Shift: false}
+	reverse['\t'] = ReverseKey{Code: uinput.KeyTab, Shift: false}
+
+	return &Keymap{Chars: chars, AltGr: altGr, Reverse: reverse}
+}
+
+// addReverseLevel adds one level of a decode table to the reverse table
+// without overwriting characters already reachable from a lower level.
+func addReverseLevel(reverse map[rune]ReverseKey, chars map[evdev.EvCode]KeyChar, shift, altGr bool, level func(KeyChar) string) {
+	// evdev key codes are numerically identical to uinput key codes, so we
+	// can cast directly. Codes are visited in order to keep the result
+	// deterministic.
+	codes := make([]evdev.EvCode, 0, len(chars))
+	for code := range chars {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	for _, code := range codes {
+		ch := level(chars[code])
+		if isDeadKey(ch) {
+			continue
+		}
+		for _, r := range ch {
+			if _, ok := reverse[r]; !ok {
+				reverse[r] = ReverseKey{Code: int(code), Shift: shift, AltGr: altGr, Alphabetic: chars[code].alphabetic()}
+			}
+		}
+	}
+}
+
+// HasAltGr reports whether Right Alt acts as AltGr (ISO_Level3_Shift).
+func (k *Keymap) HasAltGr() bool {
+	return len(k.AltGr) > 0
+}
+
+// CanType returns true if every rune in text has a reverse key mapping.
+func (k *Keymap) CanType(text string) bool {
+	for _, r := range text {
+		if _, ok := k.Reverse[r]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// separatorKeys are the buffer reset keys that type a character, which
+// can be a word separator.
+var separatorKeys = map[evdev.EvCode]string{
+	evdev.KEY_TAB:   "\t",
+	evdev.KEY_ENTER: "\n",
+}
+
 // BufferResetKeys are keys that clear the typing buffer when pressed.
 var BufferResetKeys = map[evdev.EvCode]bool{
 	evdev.KEY_ENTER:     true,
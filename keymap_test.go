@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestKeyCharChar(t *testing.T) {
+	tests := []struct {
+		kc              KeyChar
+		shift, capsLock bool
+		want            string
+	}{
+		{KeyChar{"a", "A"}, false, false, "a"},
+		{KeyChar{"a", "A"}, true, false, "A"},
+		{KeyChar{"a", "A"}, false, true, "A"},
+		{KeyChar{"a", "A"}, true, true, "a"},
+		{KeyChar{"ç", "Ç"}, false, true, "Ç"},
+		{KeyChar{"æ", "Æ"}, false, true, "Æ"},
+		// CapsLock leaves keys that are not a letter pair alone.
+		{KeyChar{"1", "!"}, false, true, "1"},
+		{KeyChar{"1", "!"}, true, true, "!"},
+		{KeyChar{"ß", "§"}, false, true, "ß"},
+		{KeyChar{"@", "Ω"}, false, true, "@"},
+		{KeyChar{"€", "€"}, true, true, "€"},
+	}
+	for _, tt := range tests {
+		if got := tt.kc.Char(tt.shift, tt.capsLock); got != tt.want {
+			t.Errorf("%q.Char(shift=%v, capsLock=%v) = %q, want %q", tt.kc, tt.shift, tt.capsLock, got, tt.want)
+		}
+	}
+}
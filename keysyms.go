@@ -0,0 +1,187 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// asciiKeysyms lists the X11 keysym names for printable ASCII, indexed
+// from 0x20 (space).
+var asciiKeysyms = [...]string{
+	"space", "exclam", "quotedbl", "numbersign", "dollar", "percent",
+	"ampersand", "apostrophe", "parenleft", "parenright", "asterisk", "plus",
+	"comma", "minus", "period", "slash",
+	"0", "1", "2", "3", "4", "5", "6", "7", "8", "9",
+	"colon", "semicolon", "less", "equal", "greater", "question", "at",
+	"A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "M",
+	"N", "O", "P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z",
+	"bracketleft", "backslash", "bracketright", "asciicircum", "underscore", "grave",
+	"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m",
+	"n", "o", "p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z",
+	"braceleft", "bar", "braceright", "asciitilde",
+}
+
+// latin1Keysyms lists the X11 keysym names for Latin-1, indexed from 0xa0.
+var latin1Keysyms = [...]string{
+	"nobreakspace", "exclamdown", "cent", "sterling", "currency", "yen",
+	"brokenbar", "section", "diaeresis", "copyright", "ordfeminine",
+	"guillemotleft", "notsign", "hyphen", "registered", "macron", "degree",
+	"plusminus", "twosuperior", "threesuperior", "acute", "mu", "paragraph",
+	"periodcentered", "cedilla", "onesuperior", "masculine", "guillemotright",
+	"onequarter", "onehalf", "threequarters", "questiondown",
+	"Agrave", "Aacute", "Acircumflex", "Atilde", "Adiaeresis", "Aring", "AE",
+	"Ccedilla", "Egrave", "Eacute", "Ecircumflex", "Ediaeresis", "Igrave",
+	"Iacute", "Icircumflex", "Idiaeresis", "ETH", "Ntilde", "Ograve", "Oacute",
+	"Ocircumflex", "Otilde", "Odiaeresis", "multiply", "Oslash", "Ugrave",
+	"Uacute", "Ucircumflex", "Udiaeresis", "Yacute", "THORN", "ssharp",
+	"agrave", "aacute", "acircumflex", "atilde", "adiaeresis", "aring", "ae",
+	"ccedilla", "egrave", "eacute", "ecircumflex", "ediaeresis", "igrave",
+	"iacute", "icircumflex", "idiaeresis", "eth", "ntilde", "ograve", "oacute",
+	"ocircumflex", "otilde", "odiaeresis", "division", "oslash", "ugrave",
+	"uacute", "ucircumflex", "udiaeresis", "yacute", "thorn", "ydiaeresis",
+}
+
+// extraKeysyms maps keysym names outside ASCII/Latin-1 that commonly appear
+// in European XKB layouts, plus legacy aliases, to their runes.
+var extraKeysyms = map[string]rune{
+	// Aliases
+	"quoteright": '\'', "quoteleft": '`', "guillemetleft": '«',
+	"guillemetright": '»', "ordmasculine": 'º', "Eth": 'Ð', "Thorn": 'Þ',
+	"Ooblique": 'Ø', "ooblique": 'ø',
+
+	// Latin Extended-A
+	"Aogonek": 'Ą', "aogonek": 'ą', "Abreve": 'Ă', "abreve": 'ă',
+	"Amacron": 'Ā', "amacron": 'ā', "Cacute": 'Ć', "cacute": 'ć',
+	"Ccaron": 'Č', "ccaron": 'č', "Dcaron": 'Ď', "dcaron": 'ď',
+	"Dstroke": 'Đ', "dstroke": 'đ', "Eogonek": 'Ę', "eogonek": 'ę',
+	"Ecaron": 'Ě', "ecaron": 'ě', "Emacron": 'Ē', "emacron": 'ē',
+	"Eabovedot": 'Ė', "eabovedot": 'ė', "Gbreve": 'Ğ', "gbreve": 'ğ',
+	"Hstroke": 'Ħ', "hstroke": 'ħ', "Iabovedot": 'İ', "idotless": 'ı',
+	"Imacron": 'Ī', "imacron": 'ī', "Iogonek": 'Į', "iogonek": 'į',
+	"kra": 'ĸ', "Lacute": 'Ĺ', "lacute": 'ĺ', "Lcaron": 'Ľ', "lcaron": 'ľ',
+	"Lstroke": 'Ł', "lstroke": 'ł', "Nacute": 'Ń', "nacute": 'ń',
+	"Ncaron": 'Ň', "ncaron": 'ň', "ENG": 'Ŋ', "eng": 'ŋ',
+	"Odoubleacute": 'Ő', "odoubleacute": 'ő', "OE": 'Œ', "oe": 'œ',
+	"Racute": 'Ŕ', "racute": 'ŕ', "Rcaron": 'Ř', "rcaron": 'ř',
+	"Sacute": 'Ś', "sacute": 'ś', "Scaron": 'Š', "scaron": 'š',
+	"Scedilla": 'Ş', "scedilla": 'ş', "Tcaron": 'Ť', "tcaron": 'ť',
+	"Tslash": 'Ŧ', "tslash": 'ŧ', "Uring": 'Ů', "uring": 'ů',
+	"Udoubleacute": 'Ű', "udoubleacute": 'ű', "Umacron": 'Ū', "umacron": 'ū',
+	"Uogonek": 'Ų', "uogonek": 'ų', "Ydiaeresis": 'Ÿ', "Zacute": 'Ź',
+	"zacute": 'ź', "Zcaron": 'Ž', "zcaron": 'ž', "Zabovedot": 'Ż',
+	"zabovedot": 'ż',
+
+	// Spacing diacritics
+	"abovedot": '˙', "breve": '˘', "caron": 'ˇ', "doubleacute": '˝',
+	"ogonek": '˛',
+
+	// Punctuation and symbols
+	"EuroSign": '€', "endash": '–', "emdash": '—', "ellipsis": '…',
+	"leftsinglequotemark": '‘', "rightsinglequotemark": '’',
+	"singlelowquotemark": '‚', "leftdoublequotemark": '“',
+	"rightdoublequotemark": '”', "doublelowquotemark": '„',
+	"dagger": '†', "doubledagger": '‡', "permille": '‰', "trademark": '™',
+	"oneeighth": '⅛', "threeeighths": '⅜', "fiveeighths": '⅝',
+	"seveneighths": '⅞', "leftarrow": '←', "uparrow": '↑',
+	"rightarrow": '→', "downarrow": '↓', "notequal": '≠',
+	"lessthanequal": '≤', "greaterthanequal": '≥', "infinity": '∞',
+	"approxeq": '≈', "numerosign": '№', "Greek_OMEGA": 'Ω',
+	"Greek_mu": 'μ', "Greek_pi": 'π',
+}
+
+// deadKeysyms maps XKB dead keysyms to the combining mark they apply to the
+// next character. Dead keys decode to their combining mark.
+var deadKeysyms = map[string]rune{
+	"dead_grave": '\u0300', "dead_acute": '\u0301', "dead_circumflex": '\u0302',
+	"dead_tilde": '\u0303', "dead_macron": '\u0304', "dead_breve": '\u0306',
+	"dead_abovedot": '\u0307', "dead_diaeresis": '\u0308', "dead_hook": '\u0309',
+	"dead_abovering": '\u030a', "dead_doubleacute": '\u030b', "dead_caron": '\u030c',
+	"dead_horn": '\u031b', "dead_belowdot": '\u0323', "dead_belowcomma": '\u0326',
+	"dead_cedilla": '\u0327', "dead_ogonek": '\u0328', "dead_belowmacron": '\u0331',
+}
+
+// deadKeySpacing maps a dead key's combining mark to the spacing character
+// typed when the dead key is followed by space or pressed twice.
+var deadKeySpacing = map[rune]rune{
+	'\u0300': '`', '\u0301': '´', '\u0302': '^', '\u0303': '~', '\u0304': '¯',
+	'\u0306': '˘', '\u0307': '˙', '\u0308': '¨', '\u030a': '˚', '\u030b': '˝',
+	'\u030c': 'ˇ', '\u0327': '¸', '\u0328': '˛',
+}
+
+// keysymRunes is the combined name → rune lookup, built at init.
+var keysymRunes map[string]rune
+
+func init() {
+	keysymRunes = make(map[string]rune, len(asciiKeysyms)+len(latin1Keysyms)+len(extraKeysyms))
+	for i, name := range asciiKeysyms {
+		keysymRunes[name] = rune(0x20 + i)
+	}
+	for i, name := range latin1Keysyms {
+		keysymRunes[name] = rune(0xa0 + i)
+	}
+	for name, r := range extraKeysyms {
+		keysymRunes[name] = r
+	}
+	for name, r := range deadKeysyms {
+		keysymRunes[name] = r
+	}
+}
+
+// keysymRune converts an XKB keysym name to the character it produces.
+// Besides named keysyms it understands the Unicode forms "U20AC" and
+// "0x10020ac", and plain hex Latin-1 codes. Dead keysyms return their
+// combining mark. Returns false for keysyms that do not produce a
+// character (modifiers, NoSymbol).
+func keysymRune(name string) (rune, bool) {
+	if r, ok := keysymRunes[name]; ok {
+		return r, true
+	}
+	if len(name) > 1 && name[0] == 'U' {
+		if v, err := strconv.ParseUint(name[1:], 16, 32); err == nil && utf8.ValidRune(rune(v)) {
+			return rune(v), true
+		}
+	}
+	if strings.HasPrefix(name, "0x") {
+		v, err := strconv.ParseUint(name[2:], 16, 32)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case v >= 0x1000100 && v <= 0x110ffff:
+			return rune(v - 0x1000000), true
+		case v >= 0x20 && v <= 0xff && v != 0x7f:
+			return rune(v), true
+		}
+	}
+	return 0, false
+}
+
+// isDeadKey reports whether a decoded key is a dead key, i.e. a lone
+// combining mark.
+func isDeadKey(ch string) bool {
+	r, size := utf8.DecodeRuneInString(ch)
+	return size > 0 && size == len(ch) && unicode.Is(unicode.Mn, r)
+}
+
+// deadKeyText returns what a dead key types on its own: its spacing
+// accent, or the combining mark if it has none.
+func deadKeyText(dead rune) string {
+	if r, ok := deadKeySpacing[dead]; ok {
+		return string(r)
+	}
+	return string(dead)
+}
+
+// composeDeadKey applies a dead key's combining mark to ch. When the pair
+// has no precomposed form, the accent and ch are typed separately.
+func composeDeadKey(dead rune, ch string) string {
+	composed := norm.NFC.String(ch + string(dead))
+	if utf8.RuneCountInString(composed) == 1 {
+		return composed
+	}
+	return deadKeyText(dead) + ch
+}
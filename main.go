@@ -6,6 +6,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -69,6 +70,16 @@ func configDir() string {
 	return filepath.Join(home, ".config", "texpand")
 }
 
+// stateDir is where texpand keeps state that outlives a run, such as
+// counter variables.
+func stateDir() string {
+	if d := os.Getenv("XDG_STATE_HOME"); d != "" {
+		return filepath.Join(d, "texpand")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "state", "texpand")
+}
+
 func run() error {
 	ensureWaylandEnv()
 
@@ -80,13 +91,22 @@ func run() error {
 		return fmt.Errorf("load app config: %w", err)
 	}
 	dbg("trigger_mode: %q", appCfg.TriggerMode)
+	dbg("keyboard_layout: %q", appCfg.KeyboardLayout)
 
 	cfg, err := LoadConfig(dir, appCfg)
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
 	}
 	for _, m := range cfg.Matches {
-		dbg("  trigger=%q replace=%q", m.Trigger, m.Replace)
+		replace := fmt.Sprintf("replace=%q", m.Replace)
+		if len(m.Replaces) > 0 {
+			replace = fmt.Sprintf("replaces=%q", m.Replaces)
+		}
+		if m.Regex != nil {
+			dbg("  regex=%q %s mode=%s", m.Regex, replace, m.TriggerMode)
+			continue
+		}
+		dbg("  trigger=%q %s mode=%s", m.Trigger, replace, m.TriggerMode)
 	}
 
 	// Retry device initialization — at boot, /dev/uinput and keyboard
@@ -134,7 +154,10 @@ func run() error {
 
 	ch := make(chan KeyEvent, 64)
 	keyboardDone := make(chan keyboardMonitorExit, 64)
+	plugins := startPlugins(appCfg.Plugins, dir)
+	defer func() { plugins.stopAll() }()
 	expander := NewExpander(cfg, vkbd)
+	expander.SetPlugins(plugins)
 
 	fmt.Printf("texpand: monitoring %d keyboard(s) — %d triggers loaded\n",
 		len(keyboards), len(cfg.Matches))
@@ -234,6 +257,12 @@ func run() error {
 				continue
 			}
 			expander.Reload(newCfg)
+			if !slices.Equal(newAppCfg.Plugins, appCfg.Plugins) {
+				plugins.stopAll()
+				plugins = startPlugins(newAppCfg.Plugins, dir)
+				expander.SetPlugins(plugins)
+			}
+			appCfg = newAppCfg
 			fmt.Printf("texpand: config reloaded — %d triggers loaded\n", len(newCfg.Matches))
 		case event, ok := <-watcher.Events:
 			if !ok {
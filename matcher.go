@@ -0,0 +1,97 @@
+package main
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// triggerIndex is a reverse trie over match triggers. Walking it with the
+// typing buffer read backwards from its last rune visits every trigger that
+// is a suffix of the buffer, so a lookup costs O(longest trigger) no matter
+// how many matches are loaded. Case-insensitive (propagate_case) triggers
+// live in a second trie walked with the buffer lowercased.
+type triggerIndex struct {
+	exact  *trieNode
+	folded *trieNode
+	maxLen int // longest trigger as typed, in bytes
+}
+
+// trieNode is one rune of a reversed trigger. matches lists the indices of
+// the matches whose trigger ends at this node, in config order.
+type trieNode struct {
+	children map[rune]*trieNode
+	matches  []int
+}
+
+// newTriggerIndex builds the reverse tries for matches.
+func newTriggerIndex(matches []Match) *triggerIndex {
+	idx := &triggerIndex{exact: &trieNode{}, folded: &trieNode{}}
+	for i, m := range matches {
+		if m.Trigger == "" {
+			continue // regex match
+		}
+		node, maxLen := idx.exact, len(m.Trigger)
+		if m.PropagateCase {
+			// Typed in another case, the trigger may take more bytes.
+			node, maxLen = idx.folded, utf8.RuneCountInString(m.Trigger)*utf8.UTFMax
+		}
+		for s := m.Trigger; s != ""; {
+			r, size := utf8.DecodeLastRuneInString(s)
+			s = s[:len(s)-size]
+			if m.PropagateCase {
+				r = unicode.ToLower(r)
+			}
+			child, ok := node.children[r]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[rune]*trieNode)
+				}
+				child = &trieNode{}
+				node.children[r] = child
+			}
+			node = child
+		}
+		node.matches = append(node.matches, i)
+		if maxLen > idx.maxLen {
+			idx.maxLen = maxLen
+		}
+	}
+	return idx
+}
+
+// lookup returns the index of the match with the longest trigger that is a
+// suffix of buf and satisfies accept. Among equally long triggers an exact
+// one wins over a case-insensitive one, then the one declared first. A nil
+// accept accepts every match.
+func (idx *triggerIndex) lookup(buf string, accept func(i int) bool) (int, bool) {
+	// Collect the nodes along both suffix paths by depth, then try them
+	// deepest first.
+	var exact, folded []*trieNode
+	exactNode, foldedNode := idx.exact, idx.folded
+	for s := buf; s != "" && (exactNode != nil || foldedNode != nil); {
+		r, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+		if exactNode != nil {
+			exactNode = exactNode.children[r]
+		}
+		if foldedNode != nil {
+			foldedNode = foldedNode.children[unicode.ToLower(r)]
+		}
+		exact = append(exact, exactNode)
+		folded = append(folded, foldedNode)
+	}
+
+	for depth := len(exact) - 1; depth >= 0; depth-- {
+		for _, node := range []*trieNode{exact[depth], folded[depth]} {
+			if node == nil {
+				continue
+			}
+			for _, m := range node.matches {
+				if accept == nil || accept(m) {
+					return m, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
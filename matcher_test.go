@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTriggerIndexLookup(t *testing.T) {
+	matches := []Match{
+		0: {Trigger: "b"},
+		1: {Trigger: "ab"},
+		2: {Trigger: "xab"},
+		3: {Trigger: "hello", PropagateCase: true},
+		4: {Trigger: "HELLO"},
+		5: {Trigger: "dup"},
+		6: {Trigger: "dup"},
+		7: {}, // regex matches have no trigger
+		8: {Trigger: "olá", PropagateCase: true},
+	}
+	idx := newTriggerIndex(matches)
+
+	tests := []struct {
+		buf    string
+		accept func(int) bool
+		want   int // -1 for no match
+	}{
+		{"zxab", nil, 2},
+		{"zzab", nil, 1},
+		{"zzzb", nil, 0},
+		{"abc", nil, -1},
+		{"", nil, -1},
+		{"say hello", nil, 3},
+		{"say Hello", nil, 3},
+		{"say HELLO", nil, 4}, // exact wins over case-insensitive
+		{"dup", nil, 5},       // first declared wins
+		{"dup", func(i int) bool { return i != 5 }, 6},
+		{"zxab", func(i int) bool { return i != 2 }, 1}, // falls back to the next longest
+		{"OLÁ", nil, 8},
+	}
+	for _, tt := range tests {
+		got, ok := idx.lookup(tt.buf, tt.accept)
+		if !ok {
+			got = -1
+		}
+		if got != tt.want {
+			t.Errorf("lookup(%q) = %d, want %d", tt.buf, got, tt.want)
+		}
+	}
+	if want := len("xab"); idx.maxLen < want {
+		t.Errorf("maxLen = %d, want at least %d", idx.maxLen, want)
+	}
+}
+
+func TestFindWordBoundary(t *testing.T) {
+	cfg := loadTestConfig(t, map[string]string{"match/m.yml": `matches:
+  - trigger: "btw"
+    replace: "by the way"
+    word: true
+  - trigger: "ly"
+    replace: "LY"
+    left_word: true
+  - trigger: "'x"
+    replace: "anywhere"
+`})
+	e := NewExpander(cfg, nil)
+
+	tests := []struct {
+		buf      string
+		boundary bool // whether the buffer starts on a word boundary
+		want     string
+	}{
+		{"btw", true, "by the way"},
+		{"say btw", false, "by the way"},
+		{"say (btw", false, "by the way"},
+		{"abtw", true, ""},
+		{"btw", false, ""}, // the buffer starts mid-word
+		{"ly", true, "LY"},
+		{"only", true, ""},
+		{"a'x", true, "anywhere"},
+	}
+	for _, tt := range tests {
+		e.bufBoundary = tt.boundary
+		c, ok := e.find(tt.buf, TriggerModeSpace, false)
+		got := ""
+		if ok {
+			got = c.match.Replace
+		}
+		if got != tt.want {
+			t.Errorf("find(%q, boundary=%v) = %q, want %q", tt.buf, tt.boundary, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkMatch measures one keystroke's trigger lookup. Its cost
+// should depend on the length of the buffer, not on the number of
+// triggers.
+func BenchmarkMatch(b *testing.B) {
+	for _, n := range []int{10, 1000, 10000} {
+		matches := make([]Match, n)
+		for i := range matches {
+			matches[i] = Match{Trigger: fmt.Sprintf(":t%05d", i)}
+		}
+		idx := newTriggerIndex(matches)
+		hit := fmt.Sprintf("some typing before the trigger :t%05d", n/2)
+		// Shares all but the first rune with every trigger.
+		miss := fmt.Sprintf("some typing before the trigger :u%05d", n/2)
+
+		b.Run(fmt.Sprintf("hit/%d", n), func(b *testing.B) {
+			for b.Loop() {
+				if _, ok := idx.lookup(hit, nil); !ok {
+					b.Fatal("no match")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("miss/%d", n), func(b *testing.B) {
+			for b.Loop() {
+				if _, ok := idx.lookup(miss, nil); ok {
+					b.Fatal("unexpected match")
+				}
+			}
+		})
+	}
+}
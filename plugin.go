@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Plugins are long-running processes that provide plugin variables. They
+// speak line-delimited JSON: texpand writes a pluginRequest to the
+// plugin's stdin and the plugin writes a pluginResponse with the same id
+// to its stdout. Whatever it writes to stderr goes to texpand's stderr.
+
+// defaultPluginTimeout bounds how long a plugin variable waits for its
+// response.
+const defaultPluginTimeout = time.Second
+
+// Restarts of a crashing plugin are delayed from pluginMinBackoff,
+// doubling up to pluginMaxBackoff. A plugin that ran for longer than
+// pluginMaxBackoff starts again from the minimum.
+const (
+	pluginMinBackoff = 500 * time.Millisecond
+	pluginMaxBackoff = 30 * time.Second
+)
+
+// pluginStopGrace is how long a plugin has to exit after its stdin is
+// closed before it is killed.
+const pluginStopGrace = time.Second
+
+// PluginDef declares a plugin in config.yml.
+type PluginDef struct {
+	Name    string `yaml:"name"`
+	Cmd     string `yaml:"cmd"`
+	Timeout string `yaml:"timeout"`
+}
+
+// pluginRequest asks a plugin to resolve the variable named var.
+type pluginRequest struct {
+	ID     int               `json:"id"`
+	Method string            `json:"method"`
+	Var    string            `json:"var"`
+	Args   map[string]string `json:"args,omitempty"`
+}
+
+// pluginResponse answers the request with the same id: a value, or an
+// error message.
+type pluginResponse struct {
+	ID    int    `json:"id"`
+	Value string `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+// validatePluginDefs checks the plugins declared in config.yml.
+func validatePluginDefs(defs []PluginDef) error {
+	seen := make(map[string]bool, len(defs))
+	for _, d := range defs {
+		if d.Name == "" {
+			return fmt.Errorf("plugin with cmd %q has no name", d.Cmd)
+		}
+		if seen[d.Name] {
+			return fmt.Errorf("duplicate plugin %q", d.Name)
+		}
+		seen[d.Name] = true
+		if d.Cmd == "" {
+			return fmt.Errorf("plugin %q: missing cmd", d.Name)
+		}
+		if _, err := paramTimeout(VarParams{Timeout: d.Timeout}, defaultPluginTimeout); err != nil {
+			return fmt.Errorf("plugin %q: %w", d.Name, err)
+		}
+	}
+	return nil
+}
+
+// validatePluginVar checks a plugin variable's params at load time.
+func validatePluginVar(p VarParams) error {
+	if p.Plugin == "" {
+		return fmt.Errorf("missing plugin")
+	}
+	_, err := paramTimeout(p, defaultPluginTimeout)
+	return err
+}
+
+// checkPluginVars reports plugin variables among vars whose plugin is
+// not declared.
+func checkPluginVars(vars []VarDef, defs []PluginDef) error {
+	for _, v := range vars {
+		if v.Type != "plugin" {
+			continue
+		}
+		declared := false
+		for _, d := range defs {
+			declared = declared || d.Name == v.Params.Plugin
+		}
+		if !declared {
+			return fmt.Errorf("var %q: no plugin named %q in config.yml", v.Name, v.Params.Plugin)
+		}
+	}
+	return nil
+}
+
+// plugins are the running plugins by name.
+type plugins map[string]*plugin
+
+// startPlugins starts and supervises the declared plugins, in dir.
+func startPlugins(defs []PluginDef, dir string) plugins {
+	ps := make(plugins, len(defs))
+	for _, d := range defs {
+		timeout, err := paramTimeout(VarParams{Timeout: d.Timeout}, defaultPluginTimeout)
+		if err != nil {
+			timeout = defaultPluginTimeout // rejected at load time
+		}
+		p := &plugin{
+			def:     d,
+			dir:     dir,
+			timeout: timeout,
+			stop:    make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		go p.supervise()
+		ps[d.Name] = p
+	}
+	return ps
+}
+
+// stopAll stops every plugin and waits for them to exit.
+func (ps plugins) stopAll() {
+	for _, p := range ps {
+		close(p.stop)
+	}
+	for _, p := range ps {
+		<-p.done
+	}
+}
+
+// resolvePlugin resolves a plugin variable. {{refs}} in args values are
+// expanded first. A plugin that is not running, fails or times out
+// yields "", with a warning.
+func resolvePlugin(name string, p VarParams, vars map[string]string, ps plugins) string {
+	pl, ok := ps[p.Plugin]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: plugin var %q: plugin %q is not running\n", name, p.Plugin)
+		return ""
+	}
+	timeout := pl.timeout
+	if p.Timeout != "" {
+		if d, err := paramTimeout(p, timeout); err == nil {
+			timeout = d
+		}
+	}
+	var args map[string]string
+	if len(p.Args) > 0 {
+		args = make(map[string]string, len(p.Args))
+		for k, v := range p.Args {
+			args[k] = expandRefs(v, vars)
+		}
+	}
+
+	dbg("plugin var %q: asking plugin %q", name, p.Plugin)
+	value, err := pl.resolve(name, args, timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: plugin var %q: plugin %q: %v\n", name, p.Plugin, err)
+		return ""
+	}
+	return value
+}
+
+// plugin is one supervised plugin process.
+type plugin struct {
+	def     PluginDef
+	dir     string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	conn   *pluginConn // nil while the process is not running
+	nextID int
+
+	stop chan struct{} // closed to stop the plugin
+	done chan struct{} // closed once it has stopped
+}
+
+// pluginConn is a running plugin process and its pipes. responses is
+// closed when the process exits.
+type pluginConn struct {
+	process   *os.Process
+	stdin     *os.File
+	responses chan pluginResponse
+}
+
+// resolve sends a resolve request and waits up to timeout for its
+// response. Responses to earlier requests that timed out are skipped. A
+// plugin that does not read the request within the timeout is killed, to
+// be restarted, since it would be left with half a request.
+func (p *plugin) resolve(name string, args map[string]string, timeout time.Duration) (string, error) {
+	p.mu.Lock()
+	conn := p.conn
+	p.nextID++
+	req := pluginRequest{ID: p.nextID, Method: "resolve", Var: name, Args: args}
+	p.mu.Unlock()
+	if conn == nil {
+		return "", fmt.Errorf("not running")
+	}
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(timeout)
+	conn.stdin.SetWriteDeadline(deadline)
+	if _, err := conn.stdin.Write(append(line, '\n')); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			conn.process.Kill()
+			return "", fmt.Errorf("timed out after %v writing the request, restarting", timeout)
+		}
+		return "", fmt.Errorf("write request: %w", err)
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		select {
+		case resp, ok := <-conn.responses:
+			if !ok {
+				return "", fmt.Errorf("exited before responding")
+			}
+			if resp.ID != req.ID {
+				continue
+			}
+			if resp.Error != "" {
+				return "", fmt.Errorf("%s", resp.Error)
+			}
+			return resp.Value, nil
+		case <-timer.C:
+			return "", fmt.Errorf("timed out after %v", timeout)
+		}
+	}
+}
+
+// supervise runs the plugin until stopped, restarting it with backoff
+// whenever it exits.
+func (p *plugin) supervise() {
+	defer close(p.done)
+	backoff := pluginMinBackoff
+	for {
+		started := time.Now()
+		err := p.run()
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		if time.Since(started) > pluginMaxBackoff {
+			backoff = pluginMinBackoff
+		}
+		if err == nil {
+			err = fmt.Errorf("exited")
+		}
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: plugin %q: %v, restarting in %v\n", p.def.Name, err, backoff)
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, pluginMaxBackoff)
+	}
+}
+
+// run starts the plugin process and reads its responses until it exits.
+// When the plugin is stopped, its stdin is closed and it is killed if it
+// does not exit within pluginStopGrace.
+func (p *plugin) run() error {
+	cmd := exec.Command(defaultShell, "-c", "exec "+p.def.Cmd)
+	cmd.Dir = p.dir
+	cmd.Stderr = os.Stderr
+	// An os.Pipe rather than cmd.StdinPipe, for write deadlines.
+	stdinR, stdin, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+	cmd.Stdin = stdinR
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdinR.Close()
+		return err
+	}
+	err = cmd.Start()
+	stdinR.Close()
+	if err != nil {
+		return err
+	}
+	dbg("plugin %q: started (pid %d)", p.def.Name, cmd.Process.Pid)
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-p.stop:
+			stdin.Close()
+			select {
+			case <-exited:
+			case <-time.After(pluginStopGrace):
+				cmd.Process.Kill()
+			}
+		case <-exited:
+		}
+	}()
+
+	conn := &pluginConn{process: cmd.Process, stdin: stdin, responses: make(chan pluginResponse, 16)}
+	p.setConn(conn)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var resp pluginResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			fmt.Fprintf(os.Stderr, "texpand: WARNING: plugin %q: invalid response %q: %v\n", p.def.Name, scanner.Text(), err)
+			continue
+		}
+		select {
+		case conn.responses <- resp:
+		default:
+			dbg("plugin %q: dropped unread response %d", p.def.Name, resp.ID)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		// Nobody reads the pipe any more, so a plugin still writing to it
+		// would never exit.
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: plugin %q: read responses: %v\n", p.def.Name, err)
+		cmd.Process.Kill()
+	}
+
+	p.setConn(nil)
+	close(conn.responses)
+	err = cmd.Wait()
+	close(exited)
+	return err
+}
+
+// setConn swaps the plugin's running process.
+func (p *plugin) setConn(conn *pluginConn) {
+	p.mu.Lock()
+	p.conn = conn
+	p.mu.Unlock()
+}
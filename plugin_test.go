@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePluginPath is testdata/fakeplugin, built once by TestMain.
+var fakePluginPath string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "texpand-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fakePluginPath = filepath.Join(dir, "fakeplugin")
+	out, err := exec.Command("go", "build", "-o", fakePluginPath, "./testdata/fakeplugin").CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "build fake plugin: %v\n%s", err, out)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// startFakePlugin starts the fake plugin, stopped when the test ends.
+func startFakePlugin(t *testing.T) plugins {
+	t.Helper()
+	ps := startPlugins([]PluginDef{{Name: "fake", Cmd: "'" + fakePluginPath + "'"}}, t.TempDir())
+	t.Cleanup(ps.stopAll)
+	return ps
+}
+
+// waitRunning waits until the plugin answers and returns its pid.
+func waitRunning(t *testing.T, p *plugin) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pid, err := p.resolve("v", map[string]string{"op": "pid"}, time.Second)
+		if err == nil {
+			return pid
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("plugin not running: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPluginResolve(t *testing.T) {
+	ps := startFakePlugin(t)
+	waitRunning(t, ps["fake"])
+
+	p := VarParams{Plugin: "fake", Args: map[string]string{"op": "echo", "text": "hi {{name|upper}}"}}
+	got := resolvePlugin("v", p, map[string]string{"name": "ana"}, ps)
+	if got != "hi ANA" {
+		t.Errorf("resolvePlugin = %q, want %q", got, "hi ANA")
+	}
+}
+
+func TestPluginErrorResponse(t *testing.T) {
+	ps := startFakePlugin(t)
+	waitRunning(t, ps["fake"])
+
+	_, err := ps["fake"].resolve("v", map[string]string{"op": "error"}, time.Second)
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("resolve error = %v, want boom", err)
+	}
+	if got := resolvePlugin("v", VarParams{Plugin: "fake", Args: map[string]string{"op": "error"}}, nil, ps); got != "" {
+		t.Errorf("resolvePlugin = %q, want empty", got)
+	}
+}
+
+func TestPluginNotRunning(t *testing.T) {
+	if got := resolvePlugin("v", VarParams{Plugin: "missing"}, nil, plugins{}); got != "" {
+		t.Errorf("resolvePlugin = %q, want empty", got)
+	}
+}
+
+func TestPluginTimeout(t *testing.T) {
+	ps := startFakePlugin(t)
+	p := ps["fake"]
+	waitRunning(t, p)
+
+	start := time.Now()
+	_, err := p.resolve("v", map[string]string{"op": "sleep", "ms": "300"}, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("resolve error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("resolve took %v, want about 50ms", elapsed)
+	}
+
+	// The late answer to the timed-out request must not be taken for
+	// this one.
+	got, err := p.resolve("v", map[string]string{"op": "echo", "text": "next"}, time.Second)
+	if err != nil || got != "next" {
+		t.Errorf("resolve after timeout = %q, %v; want next", got, err)
+	}
+}
+
+func TestPluginWriteTimeout(t *testing.T) {
+	ps := startFakePlugin(t)
+	p := ps["fake"]
+	pid := waitRunning(t, p)
+
+	// While the plugin sleeps it does not read its stdin, so a request
+	// larger than the pipe buffer cannot be written.
+	if _, err := p.resolve("v", map[string]string{"op": "sleep", "ms": "5000"}, 10*time.Millisecond); err == nil {
+		t.Fatal("sleep: resolve succeeded")
+	}
+	start := time.Now()
+	_, err := p.resolve("v", map[string]string{"op": "echo", "text": strings.Repeat("x", 1<<20)}, 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "writing the request") {
+		t.Fatalf("resolve error = %v, want a write timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("resolve took %v, want about 200ms", elapsed)
+	}
+	if newPid := waitRunning(t, p); newPid == pid {
+		t.Errorf("plugin not restarted after a write timeout")
+	}
+}
+
+func TestPluginStaleID(t *testing.T) {
+	ps := startFakePlugin(t)
+	waitRunning(t, ps["fake"])
+
+	got, err := ps["fake"].resolve("v", map[string]string{"op": "stale"}, time.Second)
+	if err != nil || got != "fresh" {
+		t.Errorf("resolve = %q, %v; want fresh", got, err)
+	}
+}
+
+func TestPluginRestart(t *testing.T) {
+	ps := startFakePlugin(t)
+	p := ps["fake"]
+	pid := waitRunning(t, p)
+
+	// Each crash in a row waits twice as long before the restart.
+	for i, backoff := range []time.Duration{pluginMinBackoff, 2 * pluginMinBackoff} {
+		start := time.Now()
+		if _, err := p.resolve("v", map[string]string{"op": "crash"}, time.Second); err == nil {
+			t.Fatalf("crash %d: resolve succeeded", i)
+		}
+		newPid := waitRunning(t, p)
+		if newPid == pid {
+			t.Fatalf("crash %d: plugin not restarted (pid %s)", i, pid)
+		}
+		if elapsed := time.Since(start); elapsed < backoff {
+			t.Errorf("crash %d: restarted after %v, want at least %v", i, elapsed, backoff)
+		}
+		pid = newPid
+	}
+}
+
+func TestPluginOversizedResponse(t *testing.T) {
+	ps := startFakePlugin(t)
+	p := ps["fake"]
+	pid := waitRunning(t, p)
+
+	if _, err := p.resolve("v", map[string]string{"op": "flood"}, time.Second); err == nil {
+		t.Fatal("resolve succeeded")
+	}
+	if newPid := waitRunning(t, p); newPid == pid {
+		t.Errorf("plugin not restarted after an oversized response")
+	}
+}
+
+func TestValidatePluginDefs(t *testing.T) {
+	tests := []struct {
+		defs    []PluginDef
+		wantErr string
+	}{
+		{[]PluginDef{{Name: "a", Cmd: "x"}, {Name: "b", Cmd: "y", Timeout: "2s"}}, ""},
+		{[]PluginDef{{Cmd: "x"}}, "has no name"},
+		{[]PluginDef{{Name: "a", Cmd: "x"}, {Name: "a", Cmd: "y"}}, "duplicate plugin"},
+		{[]PluginDef{{Name: "a"}}, "missing cmd"},
+		{[]PluginDef{{Name: "a", Cmd: "x", Timeout: "soon"}}, "invalid timeout"},
+	}
+	for _, tt := range tests {
+		err := validatePluginDefs(tt.defs)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("validatePluginDefs(%v) = %v, want %q", tt.defs, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// Choice modes for replaces lists and random variables.
+const (
+	ChoiceRandom     = "random"
+	ChoiceRoundRobin = "round_robin"
+)
+
+// validChoice reports whether mode is a known choice mode or empty
+// (random).
+func validChoice(mode string) bool {
+	return mode == "" || mode == ChoiceRandom || mode == ChoiceRoundRobin
+}
+
+// validateRandomVar checks a random variable's params at load time.
+func validateRandomVar(p VarParams) error {
+	if len(p.Choices) == 0 {
+		return fmt.Errorf("missing choices")
+	}
+	if !validChoice(p.Choice) {
+		return fmt.Errorf("invalid choice %q", p.Choice)
+	}
+	return nil
+}
+
+// chooser picks which of n alternatives to use. key names the list within
+// the match being expanded: a variable name, or "" for its replaces list.
+type chooser func(key string, n int, mode string) int
+
+// rotation holds the round-robin position of every choice list, by match
+// fingerprint and then key.
+type rotation map[string]map[string]int
+
+// chooser returns the chooser for the match with the given fingerprint.
+func (r rotation) chooser(fingerprint string) chooser {
+	return func(key string, n int, mode string) int {
+		if mode != ChoiceRoundRobin {
+			return rand.IntN(n)
+		}
+		pos := r[fingerprint]
+		if pos == nil {
+			pos = make(map[string]int)
+			r[fingerprint] = pos
+		}
+		i := pos[key] % n
+		pos[key] = i + 1
+		return i
+	}
+}
+
+// prune drops the positions of matches that are no longer loaded, so
+// unchanged matches keep rotating across reloads.
+func (r rotation) prune(matches []Match) {
+	loaded := make(map[string]bool, len(matches))
+	for _, m := range matches {
+		loaded[m.fingerprint] = true
+	}
+	for fp := range r {
+		if !loaded[fp] {
+			delete(r, fp)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/lib/math"
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultScriptTimeout bounds how long a script variable may run.
+const defaultScriptTimeout = time.Second
+
+// maxScriptSteps bounds the work a script may do, so a runaway loop stops
+// well before the timeout instead of spinning a CPU core until then. It
+// allows a few milliseconds of computation.
+const maxScriptSteps = 1_000_000
+
+// scriptModules are the modules scripts may use. There is no load() and
+// no file, network or process access.
+var scriptModules = starlark.StringDict{
+	"json": json.Module,
+	"math": math.Module,
+	"time": starlarktime.Module,
+}
+
+// scriptFileOptions enables the Starlark extensions scripts may need.
+var scriptFileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+	Recursion:       true,
+}
+
+// script is a script variable's Starlark source, compiled once at load
+// time. refs are the variables it reads as vars["name"] or
+// vars.get("name").
+type script struct {
+	prog *starlark.Program
+	refs []string
+	src  string
+}
+
+// UnmarshalYAML compiles a script at load time.
+func (s *script) UnmarshalYAML(node *yaml.Node) error {
+	compiled, err := compileScript(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*s = compiled
+	return nil
+}
+
+// MarshalText returns the script source, so match fingerprints see it.
+func (s script) MarshalText() ([]byte, error) {
+	return []byte(s.src), nil
+}
+
+// compileScript parses and resolves src. A script that is a single
+// expression has its value as result.
+func compileScript(src string) (script, error) {
+	s := script{src: src}
+	body := src
+	if _, err := scriptFileOptions.ParseExpr("script", src, 0); err == nil {
+		body = "result = (" + src + "\n)"
+	}
+	f, err := scriptFileOptions.Parse("script", body, 0)
+	if err != nil {
+		return s, err
+	}
+	for _, stmt := range f.Stmts {
+		if load, ok := stmt.(*syntax.LoadStmt); ok {
+			line, _ := load.Span()
+			return s, fmt.Errorf("%s: load() is not supported", line)
+		}
+	}
+	s.prog, err = starlark.FileProgram(f, func(name string) bool {
+		return name == "vars" || name == "now" || scriptModules.Has(name)
+	})
+	if err != nil {
+		return s, err
+	}
+	s.refs = scriptRefs(f)
+	return s, nil
+}
+
+// scriptRefs returns the variable names f reads with a string literal
+// key, in order of first use.
+func scriptRefs(f *syntax.File) []string {
+	var refs []string
+	add := func(e syntax.Expr) {
+		if lit, ok := e.(*syntax.Literal); ok && lit.Token == syntax.STRING {
+			if name := lit.Value.(string); !slices.Contains(refs, name) {
+				refs = append(refs, name)
+			}
+		}
+	}
+	isVars := func(e syntax.Expr) bool {
+		id, ok := e.(*syntax.Ident)
+		return ok && id.Name == "vars"
+	}
+	syntax.Walk(f, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.IndexExpr:
+			if isVars(n.X) {
+				add(n.Y)
+			}
+		case *syntax.CallExpr:
+			if dot, ok := n.Fn.(*syntax.DotExpr); ok && isVars(dot.X) && dot.Name.Name == "get" && len(n.Args) > 0 {
+				add(n.Args[0])
+			}
+		}
+		return true
+	})
+	return refs
+}
+
+// validateScriptVar checks a script variable's params at load time. The
+// script itself was compiled while decoding.
+func validateScriptVar(p VarParams) error {
+	if p.Script.prog == nil {
+		return fmt.Errorf("missing script")
+	}
+	_, err := paramTimeout(p, defaultScriptTimeout)
+	return err
+}
+
+// resolveScript runs a script variable and returns the string value of
+// its result global. Scripts see the resolved variables as the frozen
+// dict vars and the expansion time as now. A script that fails, times out
+// or sets no result yields "", with a warning.
+func resolveScript(name string, p VarParams, vars map[string]string, now time.Time) string {
+	timeout, err := paramTimeout(p, defaultScriptTimeout)
+	if err != nil {
+		timeout = defaultScriptTimeout
+	}
+
+	dict := starlark.NewDict(len(vars))
+	for k, v := range vars {
+		dict.SetKey(starlark.String(k), starlark.String(v))
+	}
+	predeclared := starlark.StringDict{
+		"vars": dict,
+		"now":  starlarktime.Time(now),
+	}
+	for k, v := range scriptModules {
+		predeclared[k] = v
+	}
+	predeclared.Freeze()
+
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			dbg("script var %q: %s", name, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(maxScriptSteps)
+	starlarktime.SetNow(thread, func() (time.Time, error) { return now, nil })
+	timer := time.AfterFunc(timeout, func() { thread.Cancel("timeout") })
+	defer timer.Stop()
+
+	dbg("script var %q: running", name)
+	globals, err := p.Script.prog.Init(thread, predeclared)
+	if err != nil {
+		if !timer.Stop() {
+			fmt.Fprintf(os.Stderr, "texpand: WARNING: script var %q timed out after %v\n", name, timeout)
+		} else {
+			fmt.Fprintf(os.Stderr, "texpand: WARNING: script var %q: %v\n", name, err)
+		}
+		return ""
+	}
+	result, ok := globals["result"]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: script var %q did not set result\n", name)
+		return ""
+	}
+	if s, ok := starlark.AsString(result); ok {
+		return s
+	}
+	if result == starlark.None {
+		return ""
+	}
+	return result.String()
+}
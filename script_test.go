@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveScript(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	vars := map[string]string{"name": "ana", "days": "3"}
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"expression", `vars["name"].upper() + "!"`, "ANA!"},
+		{"result global", "n = int(vars[\"days\"])\nresult = \"x\" * n", "xxx"},
+		{"non-string result", "result = 6 * 7", "42"},
+		{"none result", "result = None", ""},
+		{"now", `now.format("2006-01-02")`, "2026-10-16"},
+		{"time.now is the expansion time", `time.now().format("15:04")`, "09:30"},
+		{"modules", `json.encode({"n": math.floor(2.7)})`, `{"n":2}`},
+		{"vars.get default", `vars.get("missing", "none")`, "none"},
+		{"missing result", "x = 1", ""},
+		{"runtime error", `vars["missing"]`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := compileScript(tt.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resolveScript("v", VarParams{Script: s}, vars, now); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveScriptStepLimit(t *testing.T) {
+	s, err := compileScript("n = 0\nwhile True:\n    n += 1\nresult = str(n)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	got := resolveScript("v", VarParams{Script: s, Timeout: "10s"}, nil, time.Now())
+	if got != "" {
+		t.Errorf("got %q, want empty", got)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("runaway loop ran for %v; the step limit should stop it first", elapsed)
+	}
+}
+
+func TestCompileScript(t *testing.T) {
+	s, err := compileScript(`vars["a"] + vars.get("b", "") + ("c" in vars and "y" or "n")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.refs, ","); got != "a,b" {
+		t.Errorf("refs = %s, want a,b", got)
+	}
+	for _, bad := range []string{"1 +", `open("x")`, `load("x.star", "y")`} {
+		if _, err := compileScript(bad); err == nil {
+			t.Errorf("compileScript(%q) succeeded, want an error", bad)
+		}
+	}
+}
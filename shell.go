@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultShell runs shell variable commands unless the shell param is set.
+const defaultShell = "sh"
+
+// defaultShellTimeout bounds how long a shell variable may run.
+const defaultShellTimeout = 3 * time.Second
+
+// shellRefPrefix names the environment variables that carry the values
+// of a command's {{refs}}.
+const shellRefPrefix = "TEXPAND_REF_"
+
+// validateShellVar checks a shell variable's params at load time.
+func validateShellVar(p VarParams) error {
+	if p.Cmd == "" {
+		return fmt.Errorf("missing cmd")
+	}
+	for k := range p.Env {
+		if !validShellName(k) {
+			return fmt.Errorf("env: %q is not a valid variable name", k)
+		}
+		if strings.HasPrefix(k, shellRefPrefix) {
+			return fmt.Errorf("env: %q is reserved", k)
+		}
+	}
+	if _, _, err := shellCommand(p.Shell, p.Cmd, nil); err != nil {
+		return err
+	}
+	_, err := paramTimeout(p, defaultShellTimeout)
+	return err
+}
+
+// posixShells are the shells whose parameter syntax shellCommand writes,
+// by program name.
+var posixShells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true,
+	"ksh": true, "mksh": true, "ash": true, "yash": true,
+}
+
+// validShellName reports whether name can be used as a shell variable.
+func validShellName(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// shellCommand replaces the {{refs}} in cmd, run by shell, with
+// references to environment variables holding their values, returned as
+// env entries. The shell expands them as parameters, so resolved text such
+// as the clipboard is never parsed as shell code. A ref stands for one
+// word: outside quotes it becomes "${…}", inside double quotes ${…}.
+// Refs inside single quotes, which the shell would not expand, and refs
+// for shells without sh syntax are errors.
+func shellCommand(shell, cmd string, vars map[string]string) (string, []string, error) {
+	parts, err := parseTemplate(cmd)
+	if err != nil {
+		return cmd, nil, nil // rejected at load time
+	}
+	if shell == "" {
+		shell = defaultShell
+	}
+	posix := posixShells[filepath.Base(shell)]
+
+	var b strings.Builder
+	var env []string
+	var q shellQuote
+	for _, part := range parts {
+		if part.ref == nil || part.ref.literal {
+			text := render([]tmplPart{part}, nil)
+			q.scan(text)
+			b.WriteString(text)
+			continue
+		}
+		ref := "{{" + part.ref.name + "}}"
+		switch {
+		case !posix:
+			return "", nil, fmt.Errorf("%s in cmd needs an sh-compatible shell, not %q; pass it in env instead", ref, shell)
+		case q.state == '\'' || q.state == '$':
+			return "", nil, fmt.Errorf("%s is inside single quotes, where the shell does not expand it; use \"%s\"", ref, ref)
+		case q.escaped:
+			return "", nil, fmt.Errorf("%s follows a backslash", ref)
+		}
+		name := fmt.Sprintf("%s%d", shellRefPrefix, len(env))
+		env = append(env, name+"="+render([]tmplPart{part}, vars))
+		if q.state == '"' {
+			b.WriteString("${" + name + "}")
+		} else {
+			b.WriteString("\"${" + name + "}\"")
+		}
+	}
+	return b.String(), env, nil
+}
+
+// shellQuote tracks the sh quoting context at the end of the text
+// scanned so far: state is 0 outside quotes, or the open quote (' or ",
+// or $ for bash's $'…'); escaped is set after an unquoted backslash.
+type shellQuote struct {
+	state   byte
+	escaped bool
+}
+
+func (q *shellQuote) scan(text string) {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if q.escaped {
+			q.escaped = false
+			continue
+		}
+		switch q.state {
+		case 0:
+			switch {
+			case c == '\\':
+				q.escaped = true
+			case c == '\'' || c == '"':
+				q.state = c
+			case c == '$' && i+1 < len(text) && text[i+1] == '\'':
+				q.state = '$'
+				i++
+			}
+		case '\'':
+			if c == '\'' {
+				q.state = 0
+			}
+		case '"', '$':
+			switch {
+			case c == '\\':
+				q.escaped = true
+			case c == '"' && q.state == '"', c == '\'' && q.state == '$':
+				q.state = 0
+			}
+		}
+	}
+}
+
+// resolveShell runs a shell variable's command and returns its output,
+// trimmed of surrounding whitespace unless trim is false. {{refs}} in cmd
+// become shell parameters (see shellCommand) and those in env values are
+// expanded. A command that fails or times out
+// yields whatever it printed before, with a warning.
+func resolveShell(name string, p VarParams, vars map[string]string) string {
+	timeout, err := paramTimeout(p, defaultShellTimeout)
+	if err != nil {
+		timeout = defaultShellTimeout
+	}
+	shell := p.Shell
+	if shell == "" {
+		shell = defaultShell
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	script, refEnv, err := shellCommand(p.Shell, p.Cmd, vars)
+	if err != nil {
+		return "" // rejected at load time
+	}
+	cmd := exec.CommandContext(ctx, shell, "-c", script)
+	cmd.Env = append(os.Environ(), refEnv...)
+	for k, v := range p.Env {
+		// Values go to the environment as is; the names were checked at
+		// load time so scripts can use them as "$name".
+		cmd.Env = append(cmd.Env, k+"="+expandRefs(v, vars))
+	}
+	// Background children may hold stdout open after the shell is killed.
+	cmd.WaitDelay = 100 * time.Millisecond
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	dbg("shell var %q: %s -c %q", name, shell, p.Cmd)
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: shell var %q timed out after %v\n", name, timeout)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: shell var %q: %v: %s\n", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	if p.Trim == nil || *p.Trim {
+		return strings.TrimSpace(string(out))
+	}
+	return string(out)
+}
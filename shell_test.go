@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		shell   string
+		cmd     string
+		want    string
+		wantErr string
+	}{
+		{"unquoted", "", "echo {{x}}", `echo "${TEXPAND_REF_0}"`, ""},
+		{"double quotes", "", `echo "a {{x}} b"`, `echo "a ${TEXPAND_REF_0} b"`, ""},
+		{"after a closed single quote", "", `echo 'it''s' {{x}}`, `echo 'it''s' "${TEXPAND_REF_0}"`, ""},
+		{"escaped double quote", "", `echo "\"{{x}}"`, `echo "\"${TEXPAND_REF_0}"`, ""},
+		{"escaped single quote", "", `echo \'{{x}}`, `echo \'"${TEXPAND_REF_0}"`, ""},
+		{"two refs", "bash", "echo {{x}}{{y}}", `echo "${TEXPAND_REF_0}""${TEXPAND_REF_1}"`, ""},
+		{"literal", "", `echo '{{"{{"}}'`, `echo '{{'`, ""},
+		{"shell path", "/usr/bin/zsh", "echo {{x}}", `echo "${TEXPAND_REF_0}"`, ""},
+		{"no refs in another shell", "python3", `print("hi")`, `print("hi")`, ""},
+		{"single quotes", "", "echo '{{x}}'", "", "inside single quotes"},
+		{"ANSI-C quotes", "bash", "echo $'{{x}}'", "", "inside single quotes"},
+		{"backslash", "", `echo \{{x}}`, "", "follows a backslash"},
+		{"another shell", "python3", `print("{{x}}")`, "", "sh-compatible shell"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := shellCommand(tt.shell, tt.cmd, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateShellVar(t *testing.T) {
+	tests := []struct {
+		params  VarParams
+		wantErr string
+	}{
+		{VarParams{Cmd: "echo", Env: map[string]string{"NAME_1": "x"}}, ""},
+		{VarParams{}, "missing cmd"},
+		{VarParams{Cmd: "echo", Env: map[string]string{"1NAME": "x"}}, "not a valid variable name"},
+		{VarParams{Cmd: "echo", Env: map[string]string{"A-B": "x"}}, "not a valid variable name"},
+		{VarParams{Cmd: "echo", Env: map[string]string{"TEXPAND_REF_0": "x"}}, "reserved"},
+		{VarParams{Cmd: "echo", Timeout: "never"}, "invalid timeout"},
+		{VarParams{Cmd: "echo '{{x}}'"}, "inside single quotes"},
+	}
+	for _, tt := range tests {
+		err := validateShellVar(tt.params)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("validateShellVar(%+v) = %v, want %q", tt.params, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveShell(t *testing.T) {
+	noTrim := false
+	vars := map[string]string{"clip": `$(echo pwned); echo "x" '*'`, "name": "ana"}
+
+	tests := []struct {
+		name   string
+		params VarParams
+		want   string
+	}{
+		{"trimmed", VarParams{Cmd: "echo '  hi  '"}, "hi"},
+		{"untrimmed", VarParams{Cmd: "echo hi", Trim: &noTrim}, "hi\n"},
+		{"ref is not code", VarParams{Cmd: "echo {{clip}}"}, vars["clip"]},
+		{"ref in double quotes", VarParams{Cmd: `echo "<{{clip}}>"`}, "<" + vars["clip"] + ">"},
+		{"ref with a filter", VarParams{Cmd: "echo {{name|upper}}"}, "ANA"},
+		{"env", VarParams{Cmd: `echo "$GREETING"`, Env: map[string]string{"GREETING": "hi {{name}}"}}, "hi ana"},
+		{"env value is not code", VarParams{Cmd: `echo "$C"`, Env: map[string]string{"C": "{{clip}}"}}, vars["clip"]},
+		{"bash", VarParams{Cmd: "echo ${BASH_VERSION:+bash}", Shell: "bash"}, "bash"},
+		{"failure keeps the output", VarParams{Cmd: "echo partial; exit 3"}, "partial"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveShell("v", tt.params, vars); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveShellTimeout(t *testing.T) {
+	start := time.Now()
+	got := resolveShell("v", VarParams{Cmd: "echo early; sleep 5", Timeout: "100ms"}, nil)
+	if got != "early" {
+		t.Errorf("got %q, want the output before the timeout", got)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("resolveShell took %v, want about 100ms", elapsed)
+	}
+}
@@ -1,33 +1,167 @@
 package main
 
 import (
+	"strconv"
 	"strings"
 	"time"
 )
 
-// resolveDate replaces strftime format tokens in the string with their
-// corresponding time values. This avoids using Go's time.Format on
-// arbitrary strings, which could misinterpret literal characters as
-// layout tokens.
-func resolveDate(format string, t time.Time) string {
-	replacements := [][2]string{
-		{"%Y", t.Format("2006")},
-		{"%m", t.Format("01")},
-		{"%d", t.Format("02")},
-		{"%H", t.Format("15")},
-		{"%I", t.Format("03")},
-		{"%M", t.Format("04")},
-		{"%S", t.Format("05")},
-		{"%p", t.Format("PM")},
-		{"%a", t.Format("Mon")},
-		{"%A", t.Format("Monday")},
-		{"%b", t.Format("Jan")},
-		{"%B", t.Format("January")},
+// resolveDate formats t according to a strftime format string, in a
+// single pass so literal text (including "%%") is never re-expanded. It
+// covers the POSIX/glibc conversions, and the glibc flags "-" (no
+// padding), "_" (pad with spaces), "0" (pad with zeros) and "^"
+// (uppercase). Names come from loc; composite conversions such as %c keep
+// the C locale layout. Unknown conversions are kept as written.
+func resolveDate(format string, t time.Time, loc *dateLocale) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		start := i
+		i++
+		var flag byte
+		if strings.IndexByte("-_0^", format[i]) >= 0 && i+1 < len(format) {
+			flag = format[i]
+			i++
+		}
+		s, ok := strftimeConv(format[i], flag, t, loc)
+		if !ok {
+			b.WriteString(format[start : i+1])
+			continue
+		}
+		if flag == '^' {
+			s = strings.ToUpper(s)
+		}
+		b.WriteString(s)
 	}
+	return b.String()
+}
+
+// strftimeConv returns the text for a single conversion character.
+func strftimeConv(c, flag byte, t time.Time, loc *dateLocale) (string, bool) {
+	// num formats a number padded to width with pad unless a flag
+	// overrides the padding.
+	num := func(v, width int, pad byte) string {
+		switch flag {
+		case '-':
+			return strconv.Itoa(v)
+		case '_':
+			pad = ' '
+		case '0':
+			pad = '0'
+		}
+		s := strconv.Itoa(v)
+		if len(s) < width {
+			s = strings.Repeat(string(pad), width-len(s)) + s
+		}
+		return s
+	}
+	hour12 := t.Hour() % 12
+	if hour12 == 0 {
+		hour12 = 12
+	}
+	yday := t.YearDay() - 1
+	wday := int(t.Weekday())
+
+	switch c {
+	case '%':
+		return "%", true
+	case 'n':
+		return "\n", true
+	case 't':
+		return "\t", true
+
+	// Names
+	case 'a':
+		return loc.shortDays[wday], true
+	case 'A':
+		return loc.days[wday], true
+	case 'b', 'h':
+		return loc.shortMonths[t.Month()-1], true
+	case 'B':
+		return loc.months[t.Month()-1], true
+	case 'p':
+		return loc.amPM(t.Hour()), true
+	case 'P':
+		return strings.ToLower(loc.amPM(t.Hour())), true
+
+	// Year
+	case 'Y':
+		return num(t.Year(), 4, '0'), true
+	case 'C':
+		return num(t.Year()/100, 2, '0'), true
+	case 'y':
+		return num(t.Year()%100, 2, '0'), true
+	case 'G':
+		year, _ := t.ISOWeek()
+		return num(year, 4, '0'), true
+	case 'g':
+		year, _ := t.ISOWeek()
+		return num(year%100, 2, '0'), true
+
+	// Month and week
+	case 'm':
+		return num(int(t.Month()), 2, '0'), true
+	case 'U':
+		return num((yday+7-wday)/7, 2, '0'), true
+	case 'W':
+		return num((yday+7-(wday+6)%7)/7, 2, '0'), true
+	case 'V':
+		_, week := t.ISOWeek()
+		return num(week, 2, '0'), true
+
+	// Day
+	case 'd':
+		return num(t.Day(), 2, '0'), true
+	case 'e':
+		return num(t.Day(), 2, ' '), true
+	case 'j':
+		return num(t.YearDay(), 3, '0'), true
+	case 'u':
+		if wday == 0 {
+			return num(7, 1, '0'), true
+		}
+		return num(wday, 1, '0'), true
+	case 'w':
+		return num(wday, 1, '0'), true
+
+	// Time of day
+	case 'H':
+		return num(t.Hour(), 2, '0'), true
+	case 'k':
+		return num(t.Hour(), 2, ' '), true
+	case 'I':
+		return num(hour12, 2, '0'), true
+	case 'l':
+		return num(hour12, 2, ' '), true
+	case 'M':
+		return num(t.Minute(), 2, '0'), true
+	case 'S':
+		return num(t.Second(), 2, '0'), true
+	case 's':
+		return strconv.FormatInt(t.Unix(), 10), true
+
+	// Time zone
+	case 'z':
+		return t.Format("-0700"), true
+	case 'Z':
+		return t.Format("MST"), true
 
-	result := format
-	for _, r := range replacements {
-		result = strings.ReplaceAll(result, r[0], r[1])
+	// Composites, in the C locale
+	case 'c':
+		return resolveDate("%a %b %e %H:%M:%S %Y", t, loc), true
+	case 'D', 'x':
+		return resolveDate("%m/%d/%y", t, loc), true
+	case 'F':
+		return resolveDate("%Y-%m-%d", t, loc), true
+	case 'r':
+		return resolveDate("%I:%M:%S %p", t, loc), true
+	case 'R':
+		return resolveDate("%H:%M", t, loc), true
+	case 'T', 'X':
+		return resolveDate("%H:%M:%S", t, loc), true
 	}
-	return result
+	return "", false
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveDate(t *testing.T) {
+	// Expected values are from GNU date with TZ=UTC LC_ALL=C.
+	tue := time.Date(2024, 3, 5, 9, 7, 3, 0, time.UTC)
+	tests := []struct {
+		name   string
+		t      time.Time
+		format string
+		want   string
+	}{
+		{"literal percent", tue, "100%%", "100%"},
+		{"percent is not re-expanded", tue, "%%d %%%d", "%d %05"},
+		{"trailing percent", tue, "50%", "50%"},
+		{"unknown conversion", tue, "%Q %-Q %d", "%Q %-Q 05"},
+
+		{"day padding", tue, "%d|%-d|%e|%_d|%0e", "05|5| 5| 5|05"},
+		{"day of year", tue, "%j|%-j", "065|65"},
+		{"12-hour clock", tue, "%I %p %l %P %k", "09 AM  9 am  9"},
+		{"midnight", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "%I %p %H %k", "12 AM 00  0"},
+		{"uppercase flag", tue, "%^a %^B %b", "TUE MARCH Mar"},
+		{"composites", tue, "%F %T %D %R", "2024-03-05 09:07:03 03/05/24 09:07"},
+		{"epoch", tue, "%s", "1709629623"},
+
+		{"week numbers", tue, "%U|%W", "09|10"},
+		{"week numbers on a Sunday Jan 1", time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC), "%U|%W|%u|%w", "01|00|7|0"},
+		{"day of year in a leap year", time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC), "%j|%U|%W", "366|52|53"},
+
+		{"ISO week 53 of the previous year", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "%G|%g|%V", "2020|20|53"},
+		{"ISO week 1 of the next year", time.Date(2024, 12, 30, 23, 59, 59, 0, time.UTC), "%G|%g|%V", "2025|25|01"},
+		{"ISO week 53 into January", time.Date(2027, 1, 3, 12, 0, 0, 0, time.UTC), "%G-W%V-%u", "2026-W53-7"},
+		{"ISO week unpadded", time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), "%-V", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveDate(tt.format, tt.t, englishLocale); got != tt.want {
+				t.Errorf("resolveDate(%q) = %q, want %q", tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDateLocale(t *testing.T) {
+	pt, err := lookupLocale("pt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tue := time.Date(2024, 3, 5, 9, 7, 3, 0, time.UTC)
+	if got, want := resolveDate("%A, %-d de %B (%a %b)", tue, pt), "terça-feira, 5 de março (ter mar)"; got != want {
+		t.Errorf("resolveDate = %q, want %q", got, want)
+	}
+}
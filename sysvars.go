@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// defaultFileMaxSize caps how much of a file a file variable inserts.
+const defaultFileMaxSize = 64 << 10
+
+// systemInfo lists the values a system variable can insert.
+var systemInfo = map[string]func() (string, error){
+	"hostname": os.Hostname,
+	"username": func() (string, error) {
+		u, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	},
+	"kernel": func() (string, error) {
+		b, err := os.ReadFile("/proc/sys/kernel/osrelease")
+		return strings.TrimSpace(string(b)), err
+	},
+	"ip": primaryIP,
+}
+
+// validateEnvVar checks an env variable's params at load time.
+func validateEnvVar(p VarParams) error {
+	if p.Var == "" {
+		return fmt.Errorf("missing var")
+	}
+	return nil
+}
+
+// validateFileVar checks a file variable's params at load time.
+func validateFileVar(p VarParams) error {
+	if p.Path == "" {
+		return fmt.Errorf("missing path")
+	}
+	if p.MaxSize < 0 {
+		return fmt.Errorf("max_size must not be negative, got %d", p.MaxSize)
+	}
+	return nil
+}
+
+// validateSystemVar checks a system variable's params at load time.
+func validateSystemVar(p VarParams) error {
+	if _, ok := systemInfo[p.Info]; !ok {
+		return fmt.Errorf("invalid info %q (want hostname, username, kernel or ip)", p.Info)
+	}
+	return nil
+}
+
+// resolveEnv returns an environment variable, or the default (which may
+// contain {{refs}}) when it is unset or empty.
+func resolveEnv(p VarParams, vars map[string]string) string {
+	if v := os.Getenv(p.Var); v != "" {
+		return v
+	}
+	return expandRefs(p.Default, vars)
+}
+
+// resolveFile returns the contents of a file variable's path, relative to
+// the config dir unless absolute or starting with ~/. The path may contain
+// {{refs}}, e.g. to a hostname variable. Anything but a regular file is
+// refused. Files larger than max_size are cut at that size, on a character
+// boundary. Surrounding whitespace is trimmed unless trim is false.
+func resolveFile(name string, p VarParams, vars map[string]string, configDir string) string {
+	path := expandRefs(p.Path, vars)
+	switch {
+	case strings.HasPrefix(path, "~/"):
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, path[2:])
+	case !filepath.IsAbs(path):
+		path = filepath.Join(configDir, path)
+	}
+	maxSize := p.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultFileMaxSize
+	}
+
+	// Only regular files: opening or reading a FIFO or device could block
+	// the keyboard event loop forever. O_NONBLOCK keeps the open itself
+	// from blocking if path is swapped for a FIFO after the Stat.
+	fi, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: file var %q: %v\n", name, err)
+		return ""
+	}
+	if !fi.Mode().IsRegular() {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: file var %q: %s is not a regular file\n", name, path)
+		return ""
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: file var %q: %v\n", name, err)
+		return ""
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: file var %q: %s is not a regular file\n", name, path)
+		return ""
+	}
+	// Read one byte past the cap to tell whether the file was cut.
+	data, err := io.ReadAll(io.LimitReader(f, int64(maxSize)+1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: file var %q: %v\n", name, err)
+		return ""
+	}
+	if len(data) > maxSize {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: file var %q: %s is larger than %d bytes, truncating\n", name, path, maxSize)
+		data = data[:maxSize]
+		// Drop a character cut in half.
+		for len(data) > 0 {
+			if r, size := utf8.DecodeLastRune(data); r != utf8.RuneError || size > 1 {
+				break
+			}
+			data = data[:len(data)-1]
+		}
+	}
+
+	if p.Trim == nil || *p.Trim {
+		return strings.TrimSpace(string(data))
+	}
+	return string(data)
+}
+
+// resolveSystem returns a piece of information about this machine.
+func resolveSystem(name string, p VarParams) string {
+	v, err := systemInfo[p.Info]()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: system var %q: %v\n", name, err)
+	}
+	return v
+}
+
+// primaryIP returns the first global unicast address of an interface that
+// is up, preferring IPv4.
+func primaryIP() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	var v6 string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || !ipnet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				return ip4.String(), nil
+			}
+			if v6 == "" {
+				v6 = ipnet.IP.String()
+			}
+		}
+	}
+	if v6 == "" {
+		return "", fmt.Errorf("no network address found")
+	}
+	return v6, nil
+}
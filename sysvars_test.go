@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestResolveFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "note.txt"), []byte("  héllo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(dir, "fifo"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	noTrim := false
+
+	tests := []struct {
+		name   string
+		params VarParams
+		want   string
+	}{
+		{"relative to the config dir", VarParams{Path: "note.txt"}, "héllo"},
+		{"untrimmed", VarParams{Path: "note.txt", Trim: &noTrim}, "  héllo\n"},
+		{"cut on a character boundary", VarParams{Path: "note.txt", MaxSize: 4, Trim: &noTrim}, "  h"},
+		{"path with a ref", VarParams{Path: "{{file}}"}, "héllo"},
+		{"missing file", VarParams{Path: "missing.txt"}, ""},
+		{"directory", VarParams{Path: "."}, ""},
+		{"fifo", VarParams{Path: "fifo"}, ""},
+		{"device", VarParams{Path: "/dev/zero"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A blocking open or read would hang, so fail on a deadline.
+			got := make(chan string, 1)
+			go func() { got <- resolveFile("f", tt.params, map[string]string{"file": "note.txt"}, dir) }()
+			select {
+			case s := <-got:
+				if s != tt.want {
+					t.Errorf("got %q, want %q", s, tt.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("resolveFile blocked")
+			}
+		})
+	}
+}
+
+func TestResolveEnv(t *testing.T) {
+	t.Setenv("TEXPAND_TEST_SET", "value")
+	t.Setenv("TEXPAND_TEST_EMPTY", "")
+	t.Setenv("TEXPAND_TEST_UNSET", "") // restored after the test
+	os.Unsetenv("TEXPAND_TEST_UNSET")
+	vars := map[string]string{"host": "box"}
+
+	tests := []struct {
+		name   string
+		params VarParams
+		want   string
+	}{
+		{"set", VarParams{Var: "TEXPAND_TEST_SET", Default: "fallback"}, "value"},
+		{"unset", VarParams{Var: "TEXPAND_TEST_UNSET", Default: "fallback"}, "fallback"},
+		{"empty", VarParams{Var: "TEXPAND_TEST_EMPTY", Default: "fallback"}, "fallback"},
+		{"unset without a default", VarParams{Var: "TEXPAND_TEST_UNSET"}, ""},
+		{"default with a ref", VarParams{Var: "TEXPAND_TEST_UNSET", Default: "user@{{host}}"}, "user@box"},
+		{"set value is not expanded", VarParams{Var: "TEXPAND_TEST_SET", Default: "{{host}}"}, "value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveEnv(tt.params, vars); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSystemVar(t *testing.T) {
+	for _, info := range []string{"hostname", "username", "kernel", "ip"} {
+		if err := validateSystemVar(VarParams{Info: info}); err != nil {
+			t.Errorf("info %q: unexpected error: %v", info, err)
+		}
+	}
+	for _, info := range []string{"", "uptime", "Hostname"} {
+		err := validateSystemVar(VarParams{Info: info})
+		if err == nil || !strings.Contains(err.Error(), "invalid info") {
+			t.Errorf("info %q: got error %v, want an invalid info error", info, err)
+		}
+	}
+}
+
+func TestResolveSystem(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kernel, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := systemInfo["ip"]
+	t.Cleanup(func() { systemInfo["ip"] = ip })
+	systemInfo["ip"] = func() (string, error) { return "", errors.New("no network address found") }
+
+	tests := []struct {
+		info string
+		want string
+	}{
+		{"hostname", hostname},
+		{"username", u.Username},
+		{"kernel", strings.TrimSpace(string(kernel))},
+		{"ip", ""}, // a failure inserts nothing
+	}
+	for _, tt := range tests {
+		if got := resolveSystem("sys", VarParams{Info: tt.info}); got != tt.want {
+			t.Errorf("info %q: got %q, want %q", tt.info, got, tt.want)
+		}
+	}
+}
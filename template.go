@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// tmplPart is a piece of a template: literal text, or a {{ref}}.
+type tmplPart struct {
+	text string
+	ref  *tmplRef
+}
+
+// tmplRef is a {{name|filter|filter:arg}} reference. A quoted name such
+// as {{ "{{" }} is literal text instead of a variable.
+type tmplRef struct {
+	name    string
+	literal bool
+	filters []tmplFilter
+}
+
+// tmplFilter is one filter applied to a reference, with its argument.
+type tmplFilter struct {
+	name, arg string
+}
+
+// templateFilters are the filters a reference can be piped through. The
+// bool tells whether the filter takes an argument.
+var templateFilters = map[string]struct {
+	apply  func(s, arg string) string
+	hasArg bool
+}{
+	"upper":      {func(s, _ string) string { return strings.ToUpper(s) }, false},
+	"lower":      {func(s, _ string) string { return strings.ToLower(s) }, false},
+	"capitalize": {func(s, _ string) string { return capitalize(s) }, false},
+	"title":      {func(s, _ string) string { return capitalizeWords(s) }, false},
+	"trim":       {func(s, _ string) string { return strings.TrimSpace(s) }, false},
+	"urlencode":  {func(s, _ string) string { return url.QueryEscape(s) }, false},
+	"slug":       {func(s, _ string) string { return slugify(s) }, false},
+	"default": {func(s, arg string) string {
+		if s == "" {
+			return arg
+		}
+		return s
+	}, true},
+}
+
+// parseTemplate splits s into literal text and {{refs}}. Filter arguments
+// may be quoted ("n/a") to contain | or }}. A {{ with no closing }} is
+// literal text.
+func parseTemplate(s string) ([]tmplPart, error) {
+	var parts []tmplPart
+	for {
+		open := strings.Index(s, "{{")
+		if open < 0 {
+			if s != "" {
+				parts = append(parts, tmplPart{text: s})
+			}
+			return parts, nil
+		}
+		if open > 0 {
+			parts = append(parts, tmplPart{text: s[:open]})
+		}
+
+		// Find the closing braces, skipping quoted arguments.
+		end, inQuote := -1, false
+		for i := open + 2; i < len(s); i++ {
+			switch {
+			case inQuote && s[i] == '\\':
+				i++
+			case s[i] == '"':
+				inQuote = !inQuote
+			case !inQuote && strings.HasPrefix(s[i:], "}}"):
+				end = i
+			}
+			if end >= 0 {
+				break
+			}
+		}
+		if end < 0 {
+			parts = append(parts, tmplPart{text: s[open:]})
+			return parts, nil
+		}
+
+		ref, err := parseRef(s[open+2 : end])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s[open:end+2], err)
+		}
+		parts = append(parts, tmplPart{ref: ref})
+		s = s[end+2:]
+	}
+}
+
+// parseRef parses the inside of {{...}}.
+func parseRef(inner string) (*tmplRef, error) {
+	// Split on | outside quotes.
+	var fields []string
+	start, inQuote := 0, false
+	for i := 0; i < len(inner); i++ {
+		switch {
+		case inQuote && inner[i] == '\\':
+			i++
+		case inner[i] == '"':
+			inQuote = !inQuote
+		case !inQuote && inner[i] == '|':
+			fields = append(fields, inner[start:i])
+			start = i + 1
+		}
+	}
+	fields = append(fields, inner[start:])
+
+	ref := &tmplRef{name: strings.TrimSpace(fields[0])}
+	if strings.HasPrefix(ref.name, `"`) {
+		text, err := strconv.Unquote(ref.name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid literal %s", ref.name)
+		}
+		ref.name, ref.literal = text, true
+	}
+	if ref.name == "" && !ref.literal {
+		return nil, fmt.Errorf("missing variable name")
+	}
+	for _, f := range fields[1:] {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(f), ":")
+		filter, ok := templateFilters[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %q", name)
+		}
+		if hasArg != filter.hasArg {
+			if filter.hasArg {
+				return nil, fmt.Errorf("filter %q needs an argument", name)
+			}
+			return nil, fmt.Errorf("filter %q takes no argument", name)
+		}
+		arg = strings.TrimSpace(arg)
+		if strings.HasPrefix(arg, `"`) {
+			unquoted, err := strconv.Unquote(arg)
+			if err != nil {
+				return nil, fmt.Errorf("filter %q: invalid argument %s", name, arg)
+			}
+			arg = unquoted
+		}
+		ref.filters = append(ref.filters, tmplFilter{name: name, arg: arg})
+	}
+	return ref, nil
+}
+
+// render fills in a parsed template. Unresolved references are empty
+// before filters apply, so default can supply a value.
+func render(parts []tmplPart, vars map[string]string) string {
+	var b strings.Builder
+	for _, p := range parts {
+		if p.ref == nil {
+			b.WriteString(p.text)
+			continue
+		}
+		val := vars[p.ref.name]
+		if p.ref.literal {
+			val = p.ref.name
+		}
+		for _, f := range p.ref.filters {
+			val = templateFilters[f.name].apply(val, f.arg)
+		}
+		b.WriteString(val)
+	}
+	return b.String()
+}
+
+// expandRefs replaces {{name}} placeholders with resolved values, applying
+// their filters. Templates are checked at load time by checkRefs; one that
+// does not parse is returned as is.
+func expandRefs(s string, vars map[string]string) string {
+	parts, err := parseTemplate(s)
+	if err != nil {
+		return s
+	}
+	return render(parts, vars)
+}
+
+// templateRefs returns the names of the variables s refers to. A template
+// that does not parse refers to none.
+func templateRefs(s string) []string {
+	parts, _ := parseTemplate(s)
+	var names []string
+	for _, p := range parts {
+		if p.ref != nil && !p.ref.literal {
+			names = append(names, p.ref.name)
+		}
+	}
+	return names
+}
+
+// checkRefs reports a template that does not parse or refers to a
+// variable outside scope.
+func checkRefs(s string, scope map[string]bool) error {
+	parts, err := parseTemplate(s)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if p.ref != nil && !p.ref.literal && !scope[p.ref.name] {
+			return fmt.Errorf("unknown variable {{%s}}", p.ref.name)
+		}
+	}
+	return nil
+}
+
+// slugify lowercases s, strips accents and joins runs of letters and
+// digits with hyphens: "Olá, Mundo!" becomes "ola-mundo".
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandRefs(t *testing.T) {
+	vars := map[string]string{
+		"name":  "  ana maria  ",
+		"city":  "São Paulo",
+		"empty": "",
+		"query": "a b&c",
+	}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"plain text", "plain text"},
+		{"{{city}}", "São Paulo"},
+		{"{{ city }}!", "São Paulo!"},
+		{"{{name|trim}}", "ana maria"},
+		{"{{name|trim|upper}}", "ANA MARIA"},
+		{"{{name|trim|title}}", "Ana Maria"},
+		{"{{name|trim|capitalize}}", "Ana maria"},
+		{"{{ name | trim | upper | lower }}", "ana maria"},
+		{"{{city|slug}}", "sao-paulo"},
+		{"{{query|urlencode}}", "a+b%26c"},
+		{"{{empty|default:n/a}}", "n/a"},
+		{`{{empty|default:"n/a"|upper}}`, "N/A"},
+		{`{{empty|default:"a|b}}"}}`, "a|b}}"},
+		{"{{city|default:x}}", "São Paulo"},
+		{"{{missing|default:none}}", "none"},
+		{"{{missing}}", ""},
+
+		{`{{ "{{" }} user }}`, "{{ user }}"},
+		{`{{ "{{" }}{{city}}{{ "}}" }}`, "{{São Paulo}}"},
+		{`{{ "abc" | upper }}`, "ABC"},
+		{"unterminated {{city", "unterminated {{city"},
+		{"{{city}} and {{", "São Paulo and {{"},
+	}
+	for _, tt := range tests {
+		if got := expandRefs(tt.template, vars); got != tt.want {
+			t.Errorf("expandRefs(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{"{{name|shout}}", `unknown filter "shout"`},
+		{"{{name|default}}", `filter "default" needs an argument`},
+		{"{{name|upper:x}}", `filter "upper" takes no argument`},
+		{`{{ "a" | default:"b }}`, ""}, // unterminated quote: literal text
+		{"{{ }}", "missing variable name"},
+		{`{{ "a }}`, ""}, // no closing }} outside quotes: literal text
+	}
+	for _, tt := range tests {
+		_, err := parseTemplate(tt.template)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("parseTemplate(%q) = %v, want no error", tt.template, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseTemplate(%q) = %v, want %q", tt.template, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTemplateRefs(t *testing.T) {
+	got := templateRefs(`{{a}} {{ "{{" }} {{b|upper}} {{a}}`)
+	if strings.Join(got, ",") != "a,b,a" {
+		t.Errorf("templateRefs = %q, want [a b a]", got)
+	}
+	scope := map[string]bool{"a": true}
+	if err := checkRefs(`{{a}} {{ "lit" }}`, scope); err != nil {
+		t.Errorf("checkRefs: %v", err)
+	}
+	if err := checkRefs("{{a}} {{b}}", scope); err == nil || !strings.Contains(err.Error(), "{{b}}") {
+		t.Errorf("checkRefs = %v, want unknown variable {{b}}", err)
+	}
+}
+
+func TestLoadConfigSkipsBadTemplates(t *testing.T) {
+	cfg := loadTestConfig(t, map[string]string{
+		"match/good.yml": `matches:
+  - trigger: "'ok"
+    replace: "fine"
+  - trigger: "'hbs"
+    replace: "Hello {{user.name}}"
+  - trigger: "'lit"
+    replace: 'Hello {{ "{{" }}user.name}}'
+`,
+		"match/badglobals.yml": `global_vars:
+  - name: g
+    type: random
+    params:
+      choices: ["{{nope}}"]
+matches:
+  - trigger: "'gone"
+    replace: "{{g}}"
+`,
+	})
+	var triggers []string
+	for _, m := range cfg.Matches {
+		triggers = append(triggers, m.Trigger)
+	}
+	if got := strings.Join(triggers, ","); got != "'ok,'lit" {
+		t.Errorf("loaded triggers = %s, want 'ok,'lit", got)
+	}
+}
@@ -0,0 +1,66 @@
+// Command fakeplugin is a texpand plugin for plugin_test.go. What it does
+// with a request depends on args["op"]:
+//
+//	echo   answers args["text"]
+//	pid    answers its process id
+//	error  answers with an error
+//	sleep  answers "slept" after args["ms"] milliseconds
+//	stale  answers with a wrong id first, then with the right one
+//	crash  exits without answering
+//	flood  writes a line longer than texpand accepts, then hangs
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type request struct {
+	ID   int               `json:"id"`
+	Var  string            `json:"var"`
+	Args map[string]string `json:"args"`
+}
+
+type response struct {
+	ID    int    `json:"id"`
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func main() {
+	out := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			os.Exit(2)
+		}
+		switch req.Args["op"] {
+		case "echo":
+			out.Encode(response{ID: req.ID, Value: req.Args["text"]})
+		case "pid":
+			out.Encode(response{ID: req.ID, Value: strconv.Itoa(os.Getpid())})
+		case "error":
+			out.Encode(response{ID: req.ID, Error: "boom"})
+		case "sleep":
+			ms, _ := strconv.Atoi(req.Args["ms"])
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			out.Encode(response{ID: req.ID, Value: "slept"})
+		case "stale":
+			out.Encode(response{ID: req.ID - 1, Value: "stale"})
+			out.Encode(response{ID: req.ID, Value: "fresh"})
+		case "crash":
+			os.Exit(1)
+		case "flood":
+			// Blocks once texpand stops reading, like a real plugin would.
+			os.Stdout.WriteString(strings.Repeat("x", 2<<20) + "\n")
+			time.Sleep(time.Hour)
+		default:
+			out.Encode(response{ID: req.ID, Error: "unknown op"})
+		}
+	}
+}
@@ -1,37 +1,331 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
 	"strings"
 	"time"
 )
 
-// ResolveVars resolves global and match-level variables, processing them
-// in order so later variables can reference earlier ones via {{name}}.
-func ResolveVars(globalVars []VarDef, matchVars []VarDef, now time.Time) map[string]string {
-	resolved := make(map[string]string)
+// varEnv is the expansion-time state variables are resolved against.
+type varEnv struct {
+	now    time.Time
+	choose chooser
 
-	// Combine global + match vars; order matters (later refs earlier)
-	allVars := make([]VarDef, 0, len(globalVars)+len(matchVars))
-	allVars = append(allVars, globalVars...)
-	allVars = append(allVars, matchVars...)
+	// locale is the default locale of date variables.
+	locale string
 
-	for _, v := range allVars {
-		if v.Type == "date" {
-			t := now.Add(time.Duration(v.Params.Offset) * time.Second)
-			// First expand {{refs}} to already-resolved values
-			format := expandRefs(v.Params.Format, resolved)
-			// Then replace strftime tokens with actual date values
-			resolved[v.Name] = resolveDate(format, t)
+	// configDir is where relative file variable paths start.
+	configDir string
+
+	// calcPrecision is the default number of decimals of calc variables.
+	calcPrecision int
+
+	// plugins are the running plugins, for plugin variables.
+	plugins plugins
+
+	// match returns the replacement of the match with the given trigger,
+	// for match variables.
+	match func(trigger string) (string, error)
+}
+
+// ResolveVars resolves the variables template refers to, directly or
+// through other variables, each after the ones it refers to. Variables the
+// template does not need are never run. vars holds values known up front
+// (regex captures), which win over variables of the same name; it is
+// filled in and returned.
+func ResolveVars(globalVars []VarDef, matchVars []VarDef, template string, vars map[string]string, env varEnv) map[string]string {
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	captured := make(map[string]bool, len(vars))
+	for name := range vars {
+		captured[name] = true
+	}
+	order, err := resolveOrder(varsByName(globalVars, matchVars), templateRefs(template))
+	if err != nil {
+		// Cycles are rejected at load time.
+		fmt.Fprintf(os.Stderr, "texpand: WARNING: %v\n", err)
+	}
+	// A shadowed global comes first and its value is then replaced by
+	// the match variable extending it.
+	for _, v := range order {
+		if captured[v.Name] {
+			continue
+		}
+		vars[v.Name] = resolveVar(v, vars, env)
+	}
+	return vars
+}
+
+// resolveVar computes a single variable's value. The variables it refers
+// to are already in resolved.
+func resolveVar(v VarDef, resolved map[string]string, env varEnv) string {
+	switch v.Type {
+	case "date":
+		t := dateFor(env.now, v.Params)
+		// First expand {{refs}} to already-resolved values
+		format := expandRefs(v.Params.Format, resolved)
+		// Then replace strftime tokens with actual date values
+		locale := v.Params.Locale
+		if locale == "" {
+			locale = env.locale
+		}
+		loc, err := lookupLocale(locale)
+		if err != nil {
+			loc = englishLocale // rejected at load time
+		}
+		return resolveDate(format, t, loc)
+	case "shell":
+		return resolveShell(v.Name, v.Params, resolved)
+	case "clipboard":
+		return resolveClipboard(v.Name, v.Params, false)
+	case "selection":
+		return resolveClipboard(v.Name, v.Params, true)
+	case "random":
+		choice := v.Params.Choices[env.choose(v.Name, len(v.Params.Choices), v.Params.Choice)]
+		return expandRefs(choice, resolved)
+	case "counter":
+		return resolveCounter(v.Name, v.Params)
+	case "uuid":
+		return resolveUUID(v.Name, v.Params, env.now)
+	case "random_string":
+		return resolveRandomString(v.Name, v.Params)
+	case "env":
+		return resolveEnv(v.Params, resolved)
+	case "file":
+		return resolveFile(v.Name, v.Params, resolved, env.configDir)
+	case "system":
+		return resolveSystem(v.Name, v.Params)
+	case "calc":
+		return resolveCalc(v.Name, v.Params, resolved, env.calcPrecision)
+	case "script":
+		return resolveScript(v.Name, v.Params, resolved, env.now)
+	case "plugin":
+		return resolvePlugin(v.Name, v.Params, resolved, env.plugins)
+	case "match":
+		text, err := env.match(v.Params.Trigger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "texpand: WARNING: var %q: %v\n", v.Name, err)
+		}
+		return text
+	}
+	return ""
+}
+
+// varsByName indexes a match's variables by name. Match variables shadow
+// global ones; a shadowed global stays under outerVarKey, so a match
+// variable can refer to its own name to extend it.
+func varsByName(globalVars, matchVars []VarDef) map[string]VarDef {
+	byName := make(map[string]VarDef, len(globalVars)+len(matchVars))
+	for _, v := range globalVars {
+		byName[v.Name] = v
+	}
+	for _, v := range matchVars {
+		if outer, ok := byName[v.Name]; ok {
+			byName[outerVarKey(v.Name)] = outer
+		}
+		byName[v.Name] = v
+	}
+	return byName
+}
+
+// outerVarKey is the varsByName key of the variable name shadows. The
+// NUL byte keeps it apart from any name a config can refer to.
+func outerVarKey(name string) string {
+	return "\x00" + name
+}
+
+// varDeps returns the names of the variables v refers to.
+func varDeps(v VarDef) []string {
+	var deps []string
+	for _, t := range varTemplates(v) {
+		deps = append(deps, templateRefs(t)...)
+	}
+	if v.Type == "script" {
+		deps = append(deps, v.Params.Script.refs...)
+	}
+	return deps
+}
+
+// resolveOrder returns the variables reachable from roots through their
+// {{refs}}, each after the ones it refers to. Names that are not variables
+// (regex captures) are skipped. A variable referring to its own name
+// refers to the one it shadows. A cycle is an error.
+func resolveOrder(vars map[string]VarDef, roots []string) ([]VarDef, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(vars))
+	var order []VarDef
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		v, ok := vars[name]
+		if !ok || state[name] == done {
+			return nil
+		}
+		path = append(path, name)
+		if state[name] == visiting {
+			var names []string
+			for _, key := range path[slices.Index(path, name):] {
+				names = append(names, vars[key].Name)
+			}
+			return fmt.Errorf("variable cycle: %s", strings.Join(names, " → "))
+		}
+		state[name] = visiting
+		for _, dep := range varDeps(v) {
+			if _, ok := vars[outerVarKey(dep)]; ok && dep == name {
+				dep = outerVarKey(dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		path = path[:len(path)-1]
+		order = append(order, v)
+		return nil
+	}
+
+	for _, name := range roots {
+		if err := visit(name); err != nil {
+			return order, err
+		}
+	}
+	return order, nil
+}
+
+// checkVarCycles reports a cycle among vars at load time.
+func checkVarCycles(vars map[string]VarDef) error {
+	names := slices.Sorted(maps.Keys(vars))
+	_, err := resolveOrder(vars, names)
+	return err
+}
+
+// errUnknownVarType is returned by validateVars for a type texpand does not
+// provide, such as an espanso-only one.
+var errUnknownVarType = errors.New("unknown variable type")
+
+// validateVars checks variable params that can be checked at load time.
+func validateVars(vars []VarDef) error {
+	for _, v := range vars {
+		var err error
+		switch v.Type {
+		case "date":
+			if _, err = lookupLocale(v.Params.Locale); err == nil {
+				err = validateDateVar(v.Params)
+			}
+		case "shell":
+			err = validateShellVar(v.Params)
+		case "clipboard", "selection":
+			err = validateClipboardVar(v.Params)
+		case "random":
+			err = validateRandomVar(v.Params)
+		case "match":
+			if v.Params.Trigger == "" {
+				err = fmt.Errorf("missing trigger")
+			}
+		case "counter":
+			err = validateCounterVar(v.Params)
+		case "uuid":
+			err = validateUUIDVar(v.Params)
+		case "random_string":
+			err = validateRandomStringVar(v.Params)
+		case "env":
+			err = validateEnvVar(v.Params)
+		case "file":
+			err = validateFileVar(v.Params)
+		case "system":
+			err = validateSystemVar(v.Params)
+		case "calc":
+			err = validateCalcVar(v.Params)
+		case "script":
+			err = validateScriptVar(v.Params)
+		case "plugin":
+			err = validatePluginVar(v.Params)
+		default:
+			err = fmt.Errorf("%w %q", errUnknownVarType, v.Type)
 		}
+		if err != nil {
+			return fmt.Errorf("var %q: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// paramTimeout returns the timeout param, or def when unset. Variables
+// that run commands, scripts or plugins are bounded by a timeout since
+// expansions are resolved on the keyboard event loop.
+func paramTimeout(p VarParams, def time.Duration) (time.Duration, error) {
+	if p.Timeout == "" {
+		return def, nil
 	}
+	d, err := time.ParseDuration(p.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %w", p.Timeout, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("timeout must be positive, got %q", p.Timeout)
+	}
+	return d, nil
+}
 
-	return resolved
+// varTemplates returns the params of v that may contain {{refs}}.
+func varTemplates(v VarDef) []string {
+	switch v.Type {
+	case "date":
+		return []string{v.Params.Format}
+	case "shell":
+		t := []string{v.Params.Cmd}
+		for _, val := range v.Params.Env {
+			t = append(t, val)
+		}
+		return t
+	case "random":
+		return v.Params.Choices
+	case "env":
+		return []string{v.Params.Default}
+	case "file":
+		return []string{v.Params.Path}
+	case "calc":
+		return []string{v.Params.Expr}
+	case "plugin":
+		var t []string
+		for _, val := range v.Params.Args {
+			t = append(t, val)
+		}
+		return t
+	}
+	return nil
 }
 
-// expandRefs replaces {{name}} placeholders with resolved values.
-func expandRefs(s string, vars map[string]string) string {
-	for name, val := range vars {
-		s = strings.ReplaceAll(s, "{{"+name+"}}", val)
+// checkTemplates checks the {{refs}} in vars and in texts (replacements)
+// against the variable names in scope.
+func checkTemplates(vars []VarDef, texts []string, scope map[string]bool) error {
+	for _, v := range vars {
+		for _, t := range varTemplates(v) {
+			if err := checkRefs(t, scope); err != nil {
+				return fmt.Errorf("var %q: %w", v.Name, err)
+			}
+		}
+		if v.Type == "script" {
+			for _, ref := range v.Params.Script.refs {
+				if !scope[ref] {
+					return fmt.Errorf("var %q: unknown variable vars[%q]", v.Name, ref)
+				}
+			}
+		}
+	}
+	for _, t := range texts {
+		if err := checkRefs(t, scope); err != nil {
+			return fmt.Errorf("replace: %w", err)
+		}
 	}
-	return s
+	return nil
 }
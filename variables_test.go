@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixedChoice picks the first choice of random variables.
+func fixedChoice(string, int, string) int { return 0 }
+
+func randomVar(name, choice string) VarDef {
+	return VarDef{Name: name, Type: "random", Params: VarParams{Choices: []string{choice}}}
+}
+
+func TestResolveVarsShadowing(t *testing.T) {
+	globals := []VarDef{randomVar("x", "base"), randomVar("y", "why")}
+	env := varEnv{now: time.Now(), choose: fixedChoice}
+
+	tests := []struct {
+		name      string
+		matchVars []VarDef
+		template  string
+		want      string
+	}{
+		{"self-reference extends the global", []VarDef{randomVar("x", "{{x}}+more")}, "{{x}}", "base+more"},
+		{"plain shadowing", []VarDef{randomVar("x", "own")}, "{{x}}", "own"},
+		{"others see the extended value", []VarDef{randomVar("x", "{{x}}!"), randomVar("z", "[{{x}}]")}, "{{z}} {{y}}", "[base!] why"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkVarCycles(varsByName(globals, tt.matchVars)); err != nil {
+				t.Fatal(err)
+			}
+			vars := ResolveVars(globals, tt.matchVars, tt.template, nil, env)
+			if got := expandRefs(tt.template, vars); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveVarsCaptureWins(t *testing.T) {
+	env := varEnv{now: time.Now(), choose: fixedChoice}
+	vars := ResolveVars(nil, []VarDef{randomVar("id", "var")}, "{{id}}", map[string]string{"id": "captured"}, env)
+	if vars["id"] != "captured" {
+		t.Errorf("id = %q, want captured", vars["id"])
+	}
+}
+
+func TestCheckVarCycles(t *testing.T) {
+	tests := []struct {
+		name      string
+		globals   []VarDef
+		matchVars []VarDef
+		wantErr   string
+	}{
+		{"global self-reference", []VarDef{randomVar("x", "{{x}}")}, nil, "variable cycle: x → x"},
+		{"match self-reference without a global", nil, []VarDef{randomVar("x", "{{x}}")}, "variable cycle: x → x"},
+		{"two-variable cycle", nil, []VarDef{randomVar("a", "{{b}}"), randomVar("b", "{{a}}")}, "variable cycle: a → b → a"},
+		{"cycle through the shadowed global", []VarDef{randomVar("x", "{{y}}"), randomVar("y", "1")}, []VarDef{randomVar("x", "{{x}}"), randomVar("y", "{{x}}")}, "variable cycle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkVarCycles(varsByName(tt.globals, tt.matchVars))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkVarCycles = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
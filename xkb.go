@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	evdev "github.com/holoplot/go-evdev"
+)
+
+// xkbRoot is where the XKB data files are installed on most distributions.
+// XKB_CONFIG_ROOT overrides it, matching libxkbcommon.
+func xkbRoot() string {
+	if d := os.Getenv("XKB_CONFIG_ROOT"); d != "" {
+		return d
+	}
+	return "/usr/share/X11/xkb"
+}
+
+// maxXKBIncludeDepth bounds include recursion so a cyclic symbols file
+// cannot hang config loading.
+const maxXKBIncludeDepth = 16
+
+// xkbKeyCodes maps XKB key names to evdev key codes for the keys texpand
+// decodes (the alphanumeric block). Evdev codes are XKB keycodes minus 8.
+var xkbKeyCodes = map[string]evdev.EvCode{
+	"TLDE": evdev.KEY_GRAVE,
+	"AE01": evdev.KEY_1, "AE02": evdev.KEY_2, "AE03": evdev.KEY_3,
+	"AE04": evdev.KEY_4, "AE05": evdev.KEY_5, "AE06": evdev.KEY_6,
+	"AE07": evdev.KEY_7, "AE08": evdev.KEY_8, "AE09": evdev.KEY_9,
+	"AE10": evdev.KEY_0, "AE11": evdev.KEY_MINUS, "AE12": evdev.KEY_EQUAL,
+
+	"AD01": evdev.KEY_Q, "AD02": evdev.KEY_W, "AD03": evdev.KEY_E,
+	"AD04": evdev.KEY_R, "AD05": evdev.KEY_T, "AD06": evdev.KEY_Y,
+	"AD07": evdev.KEY_U, "AD08": evdev.KEY_I, "AD09": evdev.KEY_O,
+	"AD10": evdev.KEY_P, "AD11": evdev.KEY_LEFTBRACE, "AD12": evdev.KEY_RIGHTBRACE,
+
+	"AC01": evdev.KEY_A, "AC02": evdev.KEY_S, "AC03": evdev.KEY_D,
+	"AC04": evdev.KEY_F, "AC05": evdev.KEY_G, "AC06": evdev.KEY_H,
+	"AC07": evdev.KEY_J, "AC08": evdev.KEY_K, "AC09": evdev.KEY_L,
+	"AC10": evdev.KEY_SEMICOLON, "AC11": evdev.KEY_APOSTROPHE,
+	"BKSL": evdev.KEY_BACKSLASH, "AC12": evdev.KEY_BACKSLASH,
+
+	"LSGT": evdev.KEY_102ND,
+	"AB01": evdev.KEY_Z, "AB02": evdev.KEY_X, "AB03": evdev.KEY_C,
+	"AB04": evdev.KEY_V, "AB05": evdev.KEY_B, "AB06": evdev.KEY_N,
+	"AB07": evdev.KEY_M, "AB08": evdev.KEY_COMMA, "AB09": evdev.KEY_DOT,
+	"AB10": evdev.KEY_SLASH,
+
+	"SPCE": evdev.KEY_SPACE,
+}
+
+// xkbMerge is the merge mode of an include or key statement.
+type xkbMerge int
+
+const (
+	xkbOverride xkbMerge = iota
+	xkbAugment
+	xkbReplace
+)
+
+// xkbLoader accumulates Group1 keysym names per XKB key name while
+// following a layout's include chain.
+type xkbLoader struct {
+	root string
+	keys map[string][]string
+}
+
+// LoadKeymap builds a Keymap from the system XKB symbols for layout, which
+// uses the setxkbmap syntax ("pt", "de(nodeadkeys)"). Like setxkbmap's
+// "pc+<layout>", the "pc" symbols come first: layouts rarely define the
+// space bar or <LSGT>. An empty layout returns the built-in US keymap.
+func LoadKeymap(layout string) (*Keymap, error) {
+	layout = strings.TrimSpace(layout)
+	if layout == "" {
+		return usKeymap, nil
+	}
+
+	l := &xkbLoader{root: xkbRoot(), keys: make(map[string][]string)}
+	if err := l.include("pc+"+layout, xkbOverride, 0); err != nil {
+		return nil, fmt.Errorf("keyboard layout %q: %w", layout, err)
+	}
+
+	// Levels 3 and 4 are only reachable when Right Alt is mapped to
+	// ISO_Level3_Shift, e.g. via level3(ralt_switch).
+	hasAltGr := slices.Contains(l.keys["RALT"], "ISO_Level3_Shift")
+
+	chars := make(map[evdev.EvCode]KeyChar)
+	altGr := make(map[evdev.EvCode]KeyChar)
+	for name, code := range xkbKeyCodes {
+		levels, ok := l.keys[name]
+		if !ok {
+			continue
+		}
+		if kc, ok := xkbKeyChar(levels); ok {
+			chars[code] = kc
+		}
+		if hasAltGr && len(levels) > 2 {
+			if kc, ok := xkbKeyChar(levels[2:]); ok {
+				altGr[code] = kc
+			}
+		}
+	}
+	// The "pc" symbols alone only define the space bar and <LSGT>.
+	if !slices.ContainsFunc(slices.Collect(maps.Keys(chars)), func(code evdev.EvCode) bool {
+		return code != evdev.KEY_SPACE && code != evdev.KEY_102ND
+	}) {
+		return nil, fmt.Errorf("keyboard layout %q: no alphanumeric keys defined", layout)
+	}
+
+	return NewKeymap(chars, altGr), nil
+}
+
+// xkbKeyChar converts a pair of keysym names (levels 1–2 or 3–4) to a
+// KeyChar. A single level produces the same character with and without
+// Shift.
+func xkbKeyChar(levels []string) (KeyChar, bool) {
+	var kc KeyChar
+	if len(levels) == 0 {
+		return kc, false
+	}
+	if r, ok := keysymRune(levels[0]); ok {
+		kc.Normal = string(r)
+	}
+	if len(levels) > 1 {
+		if r, ok := keysymRune(levels[1]); ok {
+			kc.Shifted = string(r)
+		}
+	} else {
+		kc.Shifted = kc.Normal
+	}
+	return kc, kc.Normal != "" || kc.Shifted != ""
+}
+
+// include resolves an include spec such as "latin(type4)" or "pc+us:2".
+// Only components targeting the first group are followed.
+func (l *xkbLoader) include(spec string, mode xkbMerge, depth int) error {
+	if depth > maxXKBIncludeDepth {
+		return fmt.Errorf("include %q: nested too deeply", spec)
+	}
+	for _, part := range strings.FieldsFunc(spec, func(r rune) bool { return r == '+' || r == '|' }) {
+		if i := strings.IndexByte(part, ':'); i != -1 {
+			if part[i+1:] != "1" {
+				continue
+			}
+			part = part[:i]
+		}
+		file, section := part, ""
+		if i := strings.IndexByte(part, '('); i != -1 && strings.HasSuffix(part, ")") {
+			file, section = part[:i], part[i+1:len(part)-1]
+		}
+		if err := l.loadSection(file, section, mode, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSection parses one xkb_symbols section of a symbols file and merges
+// its keys. An empty section selects the file's default section.
+func (l *xkbLoader) loadSection(file, section string, mode xkbMerge, depth int) error {
+	path := filepath.Join(l.root, "symbols", filepath.FromSlash(file))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	body, err := findXKBSection(tokenizeXKB(string(data)), section)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return l.parseBody(body, mode, depth)
+}
+
+// findXKBSection returns the body tokens of the named xkb_symbols section,
+// or of the section flagged "default" (else the first) when name is empty.
+func findXKBSection(toks []string, name string) ([]string, error) {
+	var first []string
+	for i := 0; i < len(toks); i++ {
+		if toks[i] != "xkb_symbols" || i+2 >= len(toks) || toks[i+2] != "{" {
+			continue
+		}
+		secName := strings.Trim(toks[i+1], `"`)
+		isDefault := false
+		for j := i - 1; j >= 0 && isXKBIdent(toks[j]); j-- {
+			if toks[j] == "default" {
+				isDefault = true
+			}
+		}
+
+		start := i + 3
+		end := matchingBrace(toks, i+2)
+		body := toks[start:end]
+		i = end
+
+		switch {
+		case name != "" && secName == name:
+			return body, nil
+		case name == "" && isDefault:
+			return body, nil
+		case first == nil:
+			first = body
+		}
+	}
+	if name == "" && first != nil {
+		return first, nil
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no xkb_symbols section")
+	}
+	return nil, fmt.Errorf("no xkb_symbols section %q", name)
+}
+
+// parseBody walks the statements of a section body, following includes and
+// merging key definitions. Other statements are skipped.
+func (l *xkbLoader) parseBody(toks []string, mode xkbMerge, depth int) error {
+	for i := 0; i < len(toks); {
+		stmtMode := mode
+		switch toks[i] {
+		case "include", "override", "augment", "replace":
+			if toks[i] != "include" {
+				stmtMode = map[string]xkbMerge{"override": xkbOverride, "augment": xkbAugment, "replace": xkbReplace}[toks[i]]
+			}
+			if i+1 < len(toks) && strings.HasPrefix(toks[i+1], `"`) {
+				if err := l.include(strings.Trim(toks[i+1], `"`), stmtMode, depth+1); err != nil {
+					return err
+				}
+				// Include statements have no terminating ';'
+				i += 2
+				if i < len(toks) && toks[i] == ";" {
+					i++
+				}
+				continue
+			}
+			i++
+			if i >= len(toks) || toks[i] != "key" {
+				continue
+			}
+		}
+
+		if toks[i] == "key" && i+2 < len(toks) && strings.HasPrefix(toks[i+1], "<") && toks[i+2] == "{" {
+			name := strings.Trim(toks[i+1], "<>")
+			end := matchingBrace(toks, i+2)
+			if levels := xkbGroup1(toks[i+3 : end]); levels != nil {
+				l.mergeKey(name, levels, stmtMode)
+			}
+			i = skipStatement(toks, end)
+			continue
+		}
+		i = skipStatement(toks, i)
+	}
+	return nil
+}
+
+// mergeKey merges levels into a key. Override and augment merge level by
+// level, so a two-level override keeps the third and fourth levels of an
+// included definition, as xkbcomp does.
+func (l *xkbLoader) mergeKey(name string, levels []string, mode xkbMerge) {
+	cur, exists := l.keys[name]
+	if !exists || mode == xkbReplace {
+		l.keys[name] = levels
+		return
+	}
+	for i, sym := range levels {
+		if sym == "" || sym == "NoSymbol" {
+			continue
+		}
+		if i >= len(cur) {
+			cur = append(cur, make([]string, i-len(cur)+1)...)
+		}
+		if mode == xkbOverride || cur[i] == "" || cur[i] == "NoSymbol" {
+			cur[i] = sym
+		}
+	}
+	l.keys[name] = cur
+}
+
+// xkbGroup1 extracts the Group1 keysym list from a key body. It accepts both
+// the bare form `[ a, A ]` and `symbols[Group1] = [ a, A ]`.
+func xkbGroup1(toks []string) []string {
+	for i := 0; i < len(toks); i++ {
+		switch {
+		case toks[i] == "symbols" && i+5 < len(toks) && toks[i+1] == "[" &&
+			(toks[i+2] == "Group1" || toks[i+2] == "1") && toks[i+3] == "]" && toks[i+4] == "=" && toks[i+5] == "[":
+			return xkbList(toks, i+5)
+		case toks[i] == "[" && (i == 0 || toks[i-1] == ","):
+			return xkbList(toks, i)
+		case toks[i] == "[" || toks[i] == "{":
+			// Skip index brackets and nested action lists.
+			i = matchingBrace(toks, i)
+		}
+	}
+	return nil
+}
+
+// xkbList returns the comma-separated identifiers of the bracketed list
+// starting at toks[open]. Multi-keysym levels `{ a, b }` keep the first.
+func xkbList(toks []string, open int) []string {
+	end := matchingBrace(toks, open)
+	var out []string
+	cur := ""
+	for i := open + 1; i < end; i++ {
+		switch toks[i] {
+		case ",":
+			out = append(out, cur)
+			cur = ""
+		case "{":
+			j := matchingBrace(toks, i)
+			if i+1 < j {
+				cur = toks[i+1]
+			}
+			i = j
+		default:
+			if cur == "" {
+				cur = toks[i]
+			}
+		}
+	}
+	return append(out, cur)
+}
+
+// matchingBrace returns the index of the bracket closing toks[open].
+func matchingBrace(toks []string, open int) int {
+	closer := map[string]string{"{": "}", "[": "]", "(": ")"}[toks[open]]
+	depth := 0
+	for i := open; i < len(toks); i++ {
+		switch toks[i] {
+		case toks[open]:
+			depth++
+		case closer:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(toks)
+}
+
+// skipStatement returns the index just past the ';' ending the statement
+// that contains toks[i], skipping over nested brackets.
+func skipStatement(toks []string, i int) int {
+	for ; i < len(toks); i++ {
+		switch toks[i] {
+		case ";":
+			return i + 1
+		case "{", "[", "(":
+			i = matchingBrace(toks, i)
+		}
+	}
+	return i
+}
+
+// tokenizeXKB splits XKB source into identifiers, quoted strings, <KEY>
+// names and single-character punctuation, dropping comments.
+func tokenizeXKB(src string) []string {
+	var toks []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//") || c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return toks
+			}
+			i += end + 4
+		case c == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end == -1 {
+				return toks
+			}
+			toks = append(toks, src[i:i+end+2])
+			i += end + 2
+		case c == '<':
+			end := strings.IndexByte(src[i:], '>')
+			if end == -1 {
+				return toks
+			}
+			toks = append(toks, src[i:i+end+1])
+			i += end + 1
+		case isXKBIdentByte(c):
+			j := i
+			for j < len(src) && isXKBIdentByte(src[j]) {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		default:
+			toks = append(toks, string(c))
+			i++
+		}
+	}
+	return toks
+}
+
+func isXKBIdentByte(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isXKBIdent(tok string) bool {
+	return tok != "" && isXKBIdentByte(tok[0])
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	evdev "github.com/holoplot/go-evdev"
+)
+
+func TestLoadKeymap(t *testing.T) {
+	t.Setenv("XKB_CONFIG_ROOT", "testdata/xkb")
+
+	type key struct {
+		code  evdev.EvCode
+		chars KeyChar
+		altGr KeyChar // zero if the key has no third level
+	}
+	tests := []struct {
+		layout   string
+		hasAltGr bool
+		keys     []key
+	}{
+		{"test", true, []key{
+			{evdev.KEY_1, KeyChar{"2", "@"}, KeyChar{"¹", "¡"}},  // override
+			{evdev.KEY_Q, KeyChar{"q", "Q"}, KeyChar{"@", "Ω"}},  // augment
+			{evdev.KEY_A, KeyChar{"a", "A"}, KeyChar{}},          // replace
+			{evdev.KEY_Z, KeyChar{"y", "Y"}, KeyChar{"«", "<"}},  // symbols[Group1]
+			{evdev.KEY_GRAVE, KeyChar{"\u0300", "~"}, KeyChar{}}, // dead key
+			{evdev.KEY_SPACE, KeyChar{" ", " "}, KeyChar{}},      // from pc
+		}},
+		{"test(nodeadkeys)", true, []key{
+			{evdev.KEY_GRAVE, KeyChar{"`", "~"}, KeyChar{}},
+			{evdev.KEY_1, KeyChar{"2", "@"}, KeyChar{"¹", "¡"}},
+		}},
+		{"test(plain)", false, []key{
+			{evdev.KEY_A, KeyChar{"a", "A"}, KeyChar{}},
+		}},
+		{"test(groups)", true, []key{
+			{evdev.KEY_S, KeyChar{"s", "S"}, KeyChar{"ß", "§"}},
+			{evdev.KEY_D, KeyChar{"d", "D"}, KeyChar{"€", "€"}},
+		}},
+		{"fr", true, []key{
+			{evdev.KEY_Q, KeyChar{"a", "A"}, KeyChar{"æ", "Æ"}},
+			{evdev.KEY_102ND, KeyChar{"<", ">"}, KeyChar{"|", "¦"}}, // from pc
+		}},
+		{" latin ", false, []key{
+			{evdev.KEY_1, KeyChar{"1", "!"}, KeyChar{}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			km, err := LoadKeymap(tt.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if km.HasAltGr() != tt.hasAltGr {
+				t.Errorf("HasAltGr() = %v, want %v", km.HasAltGr(), tt.hasAltGr)
+			}
+			for _, k := range tt.keys {
+				if got := km.Chars[k.code]; got != k.chars {
+					t.Errorf("key %d: levels 1-2 = %q, want %q", k.code, got, k.chars)
+				}
+				if got := km.AltGr[k.code]; got != k.altGr {
+					t.Errorf("key %d: levels 3-4 = %q, want %q", k.code, got, k.altGr)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadKeymapErrors(t *testing.T) {
+	t.Setenv("XKB_CONFIG_ROOT", "testdata/xkb")
+
+	tests := []struct {
+		layout  string
+		wantErr string
+	}{
+		{"missing", "no such file"},
+		{"test(nosuch)", `no xkb_symbols section "nosuch"`},
+		{"loop", "nested too deeply"},
+		{"keyless", "no alphanumeric keys"},
+	}
+	for _, tt := range tests {
+		_, err := LoadKeymap(tt.layout)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("LoadKeymap(%q) error = %v, want %q", tt.layout, err, tt.wantErr)
+		}
+	}
+
+	if km, err := LoadKeymap(""); err != nil || km != usKeymap {
+		t.Errorf("LoadKeymap(\"\") = %p, %v; want the US keymap", km, err)
+	}
+}
+
+func TestLoadConfigKeymapFallback(t *testing.T) {
+	t.Setenv("XKB_CONFIG_ROOT", "testdata/xkb")
+
+	cfg := loadTestConfig(t, map[string]string{"config.yml": "keyboard_layout: missing\n"})
+	if cfg.Keymap != usKeymap {
+		t.Error("a missing layout did not fall back to the US keymap")
+	}
+	cfg = loadTestConfig(t, map[string]string{"config.yml": "keyboard_layout: test\n"})
+	if !cfg.Keymap.HasAltGr() {
+		t.Error("keyboard_layout: test not loaded")
+	}
+}